@@ -0,0 +1,288 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	historyTable     = "history"
+	historyHeadTable = "history_head"
+)
+
+var actorKey = contextKey("actor")
+
+var errNoRevisionData = errors.New("the revision has no data to revert to")
+
+// History actions
+const (
+	HistoryCreate  = "create"
+	HistoryUpdate  = "update"
+	HistoryDelete  = "delete"
+	HistoryRestore = "restore"
+)
+
+// History is a revision of a model, which is saved as a child of the model's
+// key by stores that have Audit set
+type History struct {
+	Key        *datastore.Key  `json:"-" datastore:"-"`
+	Revision   int64           `json:"revision"`
+	Action     string          `json:"action"`
+	AccountKey *datastore.Key  `json:"-"`
+	CreatedAt  time.Time       `json:"createdAt"`
+	Changes    []HistoryChange `json:"changes" datastore:",noindex"`
+
+	// public IDs of the keys, set by Store.History, which are exposed in place
+	// of the keys' raw encoding
+	ID        string `json:"key" datastore:"-"`
+	AccountID string `json:"accountKey" datastore:"-"`
+
+	// gob encoded copy of the model after the change, used for reverts
+	Data []byte `json:"-" datastore:",noindex"`
+}
+
+// historyHead is saved as a child of the model's key, alongside its history,
+// to hold the latest revision without reading the model's history
+type historyHead struct {
+	Revision int64
+}
+
+// HistoryChange is the formatted old and new values of a changed field
+type HistoryChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// WithActor returns a context in which the account key is recorded as making
+// the changes within the audit history. auth.Session sets this when setting
+// the session's account key.
+func WithActor(c context.Context, accountKey *datastore.Key) context.Context {
+	return context.WithValue(c, actorKey, accountKey)
+}
+
+// ActorFromContext returns the account key set with WithActor, or nil
+func ActorFromContext(c context.Context) *datastore.Key {
+	key, _ := c.Value(actorKey).(*datastore.Key)
+	return key
+}
+
+// History returns the audit history of the model, ordered by revision
+func (s Store) History(c context.Context, key *datastore.Key) ([]*History, error) {
+	if err := checkTenant(c, key); err != nil {
+		return nil, err
+	}
+	var all []*History
+	keys, err := s.backend(c).GetAll(c, NewQuery(historyTable).Ancestor(key), &all)
+	if err != nil {
+		return nil, err
+	}
+
+	// the ancestor query includes the history of the model's child entities
+	var history []*History
+	for i, k := range keys {
+		if k.Parent().Equal(key) {
+			all[i].Key, all[i].ID = k, EncodeID(k)
+			all[i].AccountID = EncodeID(all[i].AccountKey)
+			history = append(history, all[i])
+		}
+	}
+	return history, nil
+}
+
+// Revert updates the model to the state it was in after the history revision,
+// with the reverted model being loaded into dst. Soft deleted models must be
+// restored before being reverted.
+//  err := s.Revert(c, key, 3, &post)
+func (s Store) Revert(c context.Context, key *datastore.Key, revision int64, dst interface{}) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	var h History
+	err := s.backend(c).Get(c, datastore.NewKey(c, historyTable, "", revision, key), &h)
+	if err != nil {
+		return err
+	}
+	if len(h.Data) == 0 {
+		return errNoRevisionData
+	}
+
+	// gob skips zero values, which would leave any existing values in place
+	dv := reflect.ValueOf(dst).Elem()
+	dv.Set(reflect.Zero(dv.Type()))
+	if err = gob.NewDecoder(bytes.NewReader(h.Data)).Decode(dst); err != nil {
+		return fmt.Errorf("decoding revision: %v", err)
+	}
+	if v, ok := dst.(Versioner); ok {
+		v.SetModelVersion(0)
+	}
+	return s.Update(c, key, dst)
+}
+
+// savedProperties loads the saved properties of the model for audited stores
+func (s Store) savedProperties(c context.Context, key *datastore.Key) ([]datastore.Property, error) {
+	if !s.Audit || key == nil || key.Incomplete() {
+		return nil, nil
+	}
+	var props datastore.PropertyList
+	err := s.backend(c).Get(c, key, &props)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	return props, err
+}
+
+// modelProperties returns the properties of the model for audited stores
+func (s Store) modelProperties(model interface{}) ([]datastore.Property, error) {
+	if !s.Audit {
+		return nil, nil
+	}
+	return saveEntity(model)
+}
+
+// record saves the history of the change within its own transaction
+func (s Store) record(c context.Context, key *datastore.Key, action string, old []datastore.Property, model interface{}) error {
+	if !s.Audit {
+		return nil
+	}
+	return runInTransaction(s.backend(c), c, func(tc context.Context) error {
+		return s.recordTx(tc, key, action, old, model)
+	}, nil)
+}
+
+// recordTx saves the history of the change, with the old properties being nil
+// for creates, and the model being nil for deletes
+func (s Store) recordTx(c context.Context, key *datastore.Key, action string, old []datastore.Property, model interface{}) error {
+	if !s.Audit {
+		return nil
+	}
+	b := s.backend(c)
+	revision, err := s.lastRevision(c, key)
+	if err != nil {
+		return err
+	}
+	revision++
+
+	h := History{
+		Revision:   revision,
+		Action:     action,
+		AccountKey: ActorFromContext(c),
+		CreatedAt:  timeNow(),
+	}
+	var props []datastore.Property
+	if model != nil {
+		if props, err = saveEntity(model); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err = gob.NewEncoder(&buf).Encode(model); err != nil {
+			return fmt.Errorf("encoding revision: %v", err)
+		}
+		h.Data = buf.Bytes()
+	}
+	h.Changes = diffProperties(old, props)
+
+	if _, err = b.Put(c, datastore.NewKey(c, historyTable, "", revision, key), &h); err != nil {
+		return err
+	}
+	_, err = b.Put(c, historyHeadKey(c, key), &historyHead{Revision: revision})
+	return err
+}
+
+func historyHeadKey(c context.Context, key *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, historyHeadTable, "head", 0, key)
+}
+
+// lastRevision returns the model's latest revision from its history head. The
+// history recorded before the heads were saved is read to find it instead.
+func (s Store) lastRevision(c context.Context, key *datastore.Key) (int64, error) {
+	b := s.backend(c)
+	var head historyHead
+	err := b.Get(c, historyHeadKey(c, key), &head)
+	if err != datastore.ErrNoSuchEntity {
+		return head.Revision, err
+	}
+
+	keys, err := b.GetAll(c, NewQuery(historyTable).Ancestor(key).KeysOnly(), nil)
+	if err != nil {
+		return 0, err
+	}
+	var revision int64
+	for _, k := range keys {
+		if k.Parent().Equal(key) && k.IntID() > revision {
+			revision = k.IntID()
+		}
+	}
+	return revision, nil
+}
+
+// diffProperties returns the fields whose values differ
+func diffProperties(old, props []datastore.Property) []HistoryChange {
+	var names []string
+	oldValues, names := formatProperties(old, names)
+	newValues, names := formatProperties(props, names)
+
+	var changes []HistoryChange
+	for _, name := range names {
+		if oldValues[name] != newValues[name] {
+			changes = append(changes, HistoryChange{Field: name, Old: oldValues[name], New: newValues[name]})
+		}
+	}
+	return changes
+}
+
+// formatProperties formats the values of each property, with multiple valued
+// properties being formatted as a list. The names not already within names
+// are appended to it.
+func formatProperties(props []datastore.Property, names []string) (map[string]string, []string) {
+	values := make(map[string][]string)
+	multiple := make(map[string]bool)
+	for _, p := range props {
+		if _, ok := values[p.Name]; !ok {
+			names = appendMissing(names, p.Name)
+		}
+		values[p.Name] = append(values[p.Name], formatValue(p.Value))
+		multiple[p.Name] = multiple[p.Name] || p.Multiple
+	}
+
+	formatted := make(map[string]string)
+	for name, vals := range values {
+		if multiple[name] {
+			formatted[name] = fmt.Sprint(vals)
+		} else {
+			formatted[name] = vals[0]
+		}
+	}
+	return formatted, names
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case *datastore.Key:
+		if v == nil {
+			return ""
+		}
+		return v.Encode()
+	case nil:
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+func appendMissing(names []string, name string) []string {
+	for _, n := range names {
+		if n == name {
+			return names
+		}
+	}
+	return append(names, name)
+}
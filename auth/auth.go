@@ -95,13 +95,7 @@ func Signout(c context.Context, w http.ResponseWriter, r *http.Request) error {
 	}
 
 	store := newTokenStore()
-	token, err := store.Get(c, uuid)
-	if err != nil {
-		return err
-	}
-	err = datastore.Delete(c, token.Key)
-
-	return err
+	return store.Delete(c, uuid)
 }
 
 // Authenticate .
@@ -3,9 +3,11 @@ package auth
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/chrisolsen/ae"
+	"github.com/chrisolsen/ae/migrations"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 )
@@ -52,6 +54,23 @@ func (c *Credentials) Valid() error {
 	return nil
 }
 
+// UniqueValues ensures the username, regardless of its case, or the provider
+// id, can only be linked to one account
+func (c *Credentials) UniqueValues() map[string]string {
+	if len(c.ProviderID) > 0 {
+		return map[string]string{"ProviderID": c.ProviderName + ":" + c.ProviderID}
+	}
+	return map[string]string{"Username": strings.ToLower(c.Username)}
+}
+
+// BackfillCredentials returns the migration that reserves the usernames and
+// provider ids of the credentials created before they were unique across all
+// accounts
+//  migrations.Register(auth.BackfillCredentials(1))
+func BackfillCredentials(version int) migrations.Migration {
+	return migrations.BackfillUnique("credentials", version, func() interface{} { return &Credentials{} })
+}
+
 // CredentialStore .
 type CredentialStore struct {
 	ae.Store
@@ -61,10 +80,15 @@ type CredentialStore struct {
 func NewCredentialStore() CredentialStore {
 	s := CredentialStore{}
 	s.TableName = "credentials"
+	s.Model = &Credentials{}
 	return s
 }
 
-// Create .
+// Create links the credentials to the account. The username, or provider id,
+// is unique across all accounts rather than only within the account, and an
+// ae.ErrDuplicateValue is returned if it is linked to another account. The
+// credentials created before then are only checked once BackfillCredentials
+// has been run.
 func (s *CredentialStore) Create(c context.Context, creds *Credentials, accountKey *datastore.Key) (*datastore.Key, error) {
 	if err := creds.Valid(); err != nil {
 		return nil, err
@@ -72,17 +96,16 @@ func (s *CredentialStore) Create(c context.Context, creds *Credentials, accountK
 
 	var isProvider = len(creds.ProviderID) > 0
 
-	// already exists?
-	q := datastore.NewQuery(s.TableName)
-	q.Ancestor(accountKey)
-	q.KeysOnly()
+	// already exists? the credentials saved before the unique values were
+	// reserved don't have a marker until BackfillCredentials has been run
+	q := s.Query().Ancestor(accountKey).KeysOnly()
 	if isProvider {
-		q.Filter("ProviderID =", creds.ProviderID)
-		q.Filter("ProviderName =", creds.ProviderName)
+		q = q.Filter("ProviderID =", creds.ProviderID).
+			Filter("ProviderName =", creds.ProviderName)
 	} else {
-		q.Filter("Username =", creds.Username)
+		q = q.Filter("Username =", creds.Username)
 	}
-	keys, err := q.GetAll(c, nil)
+	keys, err := s.GetAll(c, q, nil)
 	if err != nil {
 		if err != datastore.ErrInvalidEntityType {
 			return nil, err
@@ -100,16 +123,18 @@ func (s *CredentialStore) Create(c context.Context, creds *Credentials, accountK
 		}
 	}
 
+	// an ae.ErrDuplicateValue is returned if the username or provider id
+	// is already linked to an account
 	return s.Store.Create(c, creds, accountKey)
 }
 
 // GetAccountKeyByProvider .
 func (s *CredentialStore) GetAccountKeyByProvider(c context.Context, creds *Credentials) (*datastore.Key, error) {
-	keys, err := datastore.NewQuery(s.TableName).
+	q := s.Query().
 		Filter("ProviderID =", creds.ProviderID).
 		Filter("ProviderName =", creds.ProviderName).
-		KeysOnly().
-		GetAll(c, nil)
+		KeysOnly()
+	keys, err := s.GetAll(c, q, nil)
 
 	if err != nil {
 		return nil, fmt.Errorf("finding account by auth provider: %v", err)
@@ -124,12 +149,12 @@ func (s *CredentialStore) GetAccountKeyByProvider(c context.Context, creds *Cred
 
 // GetByUsername .
 func (s *CredentialStore) GetByUsername(c context.Context, username string, dst interface{}) ([]*datastore.Key, error) {
-	return datastore.NewQuery(s.TableName).Filter("Username =", username).GetAll(c, dst)
+	return s.GetAll(c, s.Query().Filter("Username =", username), dst)
 }
 
 // GetByAccount .
 func (s *CredentialStore) GetByAccount(c context.Context, accountKey *datastore.Key, dst interface{}) ([]*datastore.Key, error) {
-	return datastore.NewQuery(s.TableName).Ancestor(accountKey).GetAll(c, dst)
+	return s.GetAll(c, s.Query().Ancestor(accountKey), dst)
 }
 
 // SetPassword allows the user to set their password to a new value when providing a token linked
@@ -146,10 +171,7 @@ func (s CredentialStore) SetPassword(c context.Context, password string, tokenUU
 	accountKey := t.Key.Parent()
 
 	var creds []*Credentials
-	keys, err := datastore.NewQuery(s.TableName).
-		Ancestor(accountKey).
-		Filter("ProviderID =", "").
-		GetAll(c, &creds)
+	keys, err := s.GetAll(c, s.Query().Ancestor(accountKey).Filter("ProviderID =", ""), &creds)
 
 	if err != nil {
 		return err
@@ -176,10 +198,7 @@ func (s CredentialStore) SetPassword(c context.Context, password string, tokenUU
 // UpdatePassword allows the user to set their password to a new value when providing their current password
 func (s CredentialStore) UpdatePassword(c context.Context, currentPassword, newPassword string, accountKey *datastore.Key) error {
 	var creds []*Credentials
-	keys, err := datastore.NewQuery(s.TableName).
-		Ancestor(accountKey).
-		Filter("ProviderID =", "").
-		GetAll(c, &creds)
+	keys, err := s.GetAll(c, s.Query().Ancestor(accountKey).Filter("ProviderID =", ""), &creds)
 
 	if err != nil {
 		return err
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 
+	"github.com/chrisolsen/ae"
 	"golang.org/x/net/context"
 	"google.golang.org/appengine/datastore"
 )
@@ -46,7 +47,9 @@ func (s *Session) AccountKey(c context.Context) (*datastore.Key, error) {
 	return key, nil
 }
 
-// SetAccountKey sets the key in the request context to allow for later access
+// SetAccountKey sets the key in the request context to allow for later access,
+// as well as the actor recorded within the store audit history
 func (s *Session) SetAccountKey(c context.Context, key *datastore.Key) context.Context {
+	c = ae.WithActor(c, key)
 	return context.WithValue(c, sessionKey, key.Encode())
 }
@@ -78,15 +78,18 @@ func (s tokenStore) Get(c context.Context, UUID string) (*Token, error) {
 	if len(UUID) == 0 {
 		return nil, ErrInvalidToken
 	}
-	_, err = memcache.JSON.Get(c, UUID, &cachedToken)
+	b := ae.BackendFromContext(c)
+	err = b.CacheGet(c, UUID, &cachedToken)
 	if err == nil {
 		return &cachedToken, nil
 	}
 	if err != memcache.ErrCacheMiss {
-		return nil, err
+		// tokens cached as JSON, prior to the gob encoding, can't be decoded
+		// and are treated as a miss, along with any other cache failure
+		b.CacheDelete(c, UUID)
 	}
 
-	keys, err := datastore.NewQuery(s.TableName).Filter("UUID =", UUID).GetAll(c, &tokens)
+	keys, err := s.GetAll(c, s.Query().Filter("UUID =", UUID), &tokens)
 	if err != nil {
 		return nil, err
 	}
@@ -98,11 +101,7 @@ func (s tokenStore) Get(c context.Context, UUID string) (*Token, error) {
 	}
 	tokens[0].Key = keys[0]
 
-	memcache.JSON.Set(c, &memcache.Item{
-		Key:        UUID,
-		Object:     tokens[0],
-		Expiration: time.Hour * 24 * 14,
-	})
+	b.CacheSet(c, UUID, tokens[0], time.Hour*24*14)
 
 	return tokens[0], nil
 }
@@ -119,11 +118,15 @@ func (s tokenStore) Create(c context.Context, accountKey *datastore.Key) (*Token
 	return &token, nil
 }
 
-// Delete .
+// Delete deletes the token and its cached lookup by uuid
 func (s *tokenStore) Delete(c context.Context, uuid string) error {
 	token, err := s.Get(c, uuid)
 	if err != nil {
 		return err
 	}
-	return s.Store.Delete(c, token.Key)
+	if err = s.Store.Delete(c, token.Key); err != nil {
+		return err
+	}
+	ae.BackendFromContext(c).CacheDelete(c, uuid)
+	return nil
 }
@@ -0,0 +1,318 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+var backendKey = contextKey("backend")
+
+// Backend is the persistence layer that the Store reads and writes through.
+// Implementations return datastore.ErrNoSuchEntity for missing entities and
+// memcache.ErrCacheMiss for missing cache items.
+type Backend interface {
+	Put(c context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error)
+	Get(c context.Context, key *datastore.Key, dst interface{}) error
+	Delete(c context.Context, key *datastore.Key) error
+	GetAll(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, error)
+	// GetPage is the same as GetAll, but also returns the URL safe cursor that
+	// is positioned after the last result
+	GetPage(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, string, error)
+
+	// batch versions of the above, which return an appengine.MultiError
+	// containing the per-key errors
+	PutMulti(c context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error)
+	GetMulti(c context.Context, keys []*datastore.Key, dst interface{}) error
+	DeleteMulti(c context.Context, keys []*datastore.Key) error
+
+	// RunInTransaction runs f in a transaction, with all backend calls using the
+	// passed in tc context being part of the transaction
+	RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error
+
+	CacheGet(c context.Context, key string, dst interface{}) error
+	CacheSet(c context.Context, key string, src interface{}, expiration time.Duration) error
+	CacheDelete(c context.Context, key string) error
+
+	// batch versions of the above; CacheGetMulti returns an appengine.MultiError
+	// with memcache.ErrCacheMiss for each of the missing items
+	CacheGetMulti(c context.Context, keys []string, dst interface{}) error
+	CacheSetMulti(c context.Context, keys []string, src interface{}, expiration time.Duration) error
+	CacheDeleteMulti(c context.Context, keys []string) error
+}
+
+// IDReserver is implemented by the backends that need the ids of entities
+// saved with complete keys, such as imported entities, to be reserved for them
+// to not be allocated to new entities
+type IDReserver interface {
+	ReserveIDs(c context.Context, keys []*datastore.Key) error
+}
+
+// WithBackend returns a context in which all stores, that don't have their own
+// backend set, will use the passed in backend.
+//  c := ae.WithBackend(c, ae.NewMemoryBackend())
+func WithBackend(c context.Context, b Backend) context.Context {
+	return context.WithValue(c, backendKey, b)
+}
+
+// BackendFromContext returns the backend set within the context, or the App
+// Engine backend if none is set
+func BackendFromContext(c context.Context) Backend {
+	if b, ok := c.Value(backendKey).(Backend); ok {
+		return b
+	}
+	return AppEngineBackend{}
+}
+
+// RunInTransaction runs f in a transaction of the context's backend, within
+// which the Store's methods join the transaction rather than running their own.
+// Store methods can't be called within transactions started by
+// datastore.RunInTransaction, as nested transactions aren't supported. The
+// transaction must be cross group for stores with unique values or audit
+// history. The stores' cache clearing, indexing and events are queued until it
+// has committed, and are dropped if it fails.
+//  err := ae.RunInTransaction(c, func(tc context.Context) error {
+//  	if err := accounts.Update(tc, accountKey, &account); err != nil {
+//  		return err
+//  	}
+//  	return posts.Delete(tc, postKey)
+//  }, &datastore.TransactionOptions{XG: true})
+func RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return runInTransaction(BackendFromContext(c), c, f, opts)
+}
+
+var txKey = contextKey("tx")
+
+// txQueue holds the work of a transaction attempt that is run once it commits
+type txQueue struct {
+	fns []func(c context.Context)
+}
+
+// runInTransaction runs f within the transaction of the context, if there is
+// one, otherwise within a new transaction of the backend, after which the
+// queued work is run with the context outside of the transaction
+func runInTransaction(b Backend, c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	if inTransaction(c) {
+		return f(c)
+	}
+	var q *txQueue
+	err := b.RunInTransaction(c, func(tc context.Context) error {
+		// the work of failed attempts is dropped when the transaction retries
+		q = &txQueue{}
+		return f(context.WithValue(tc, txKey, q))
+	}, opts)
+	if err != nil {
+		return err
+	}
+	for _, fn := range q.fns {
+		fn(c)
+	}
+	return nil
+}
+
+// afterCommit runs fn once the transaction of the context started by
+// RunInTransaction has committed, or straight away outside of one
+func afterCommit(c context.Context, fn func(c context.Context)) {
+	if q, ok := c.Value(txKey).(*txQueue); ok {
+		q.fns = append(q.fns, fn)
+		return
+	}
+	fn(c)
+}
+
+// inTransaction indicates if the context is that of a transaction started by
+// RunInTransaction or a memory backend
+func inTransaction(c context.Context) bool {
+	return c.Value(txKey) != nil || c.Value(memoryTxKey) != nil
+}
+
+// AppEngineBackend is the default backend that uses the App Engine datastore
+// and memcache services
+type AppEngineBackend struct{}
+
+// Put saves the entity to the datastore
+func (AppEngineBackend) Put(c context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	return datastore.Put(c, key, src)
+}
+
+// Get loads the entity from the datastore
+func (AppEngineBackend) Get(c context.Context, key *datastore.Key, dst interface{}) error {
+	return datastore.Get(c, key, dst)
+}
+
+// Delete deletes the entity from the datastore
+func (AppEngineBackend) Delete(c context.Context, key *datastore.Key) error {
+	return datastore.Delete(c, key)
+}
+
+// GetAll runs the query against the datastore
+func (AppEngineBackend) GetAll(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	dq, err := q.datastoreQuery()
+	if err != nil {
+		return nil, err
+	}
+	return dq.GetAll(c, dst)
+}
+
+// GetPage iterates over the query results returning the end cursor
+func (AppEngineBackend) GetPage(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, string, error) {
+	dq, err := q.datastoreQuery()
+	if err != nil {
+		return nil, "", err
+	}
+	var dv reflect.Value
+	if !q.keysOnly {
+		if dv, err = sliceValue(dst); err != nil {
+			return nil, "", err
+		}
+	}
+
+	var keys []*datastore.Key
+	var loadErr error
+	t := dq.Run(c)
+	for {
+		var ev reflect.Value
+		var elem interface{}
+		if !q.keysOnly {
+			ev = newSliceElem(dv)
+			elem = elemPointer(ev)
+		}
+		key, err := t.Next(elem)
+		if err == datastore.Done {
+			break
+		}
+		if err != nil {
+			if _, ok := err.(*datastore.ErrFieldMismatch); !ok {
+				return nil, "", err
+			}
+			loadErr = err
+		}
+		keys = append(keys, key)
+		if !q.keysOnly {
+			dv.Set(reflect.Append(dv, ev))
+		}
+	}
+
+	cursor, err := t.Cursor()
+	if err != nil {
+		return nil, "", err
+	}
+	return keys, cursor.String(), loadErr
+}
+
+// PutMulti saves the entities to the datastore
+func (AppEngineBackend) PutMulti(c context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	return datastore.PutMulti(c, keys, src)
+}
+
+// ReserveIDs allocates the ids up to the largest one of each kind and parent,
+// for them to not be assigned to new entities
+func (AppEngineBackend) ReserveIDs(c context.Context, keys []*datastore.Key) error {
+	max := make(map[string]*datastore.Key)
+	for _, key := range keys {
+		if key.IntID() == 0 {
+			continue
+		}
+		id := key.Namespace() + "/" + key.Kind()
+		if key.Parent() != nil {
+			id += "/" + key.Parent().Encode()
+		}
+		if k, ok := max[id]; !ok || key.IntID() > k.IntID() {
+			max[id] = key
+		}
+	}
+	for _, key := range max {
+		nc, err := appengine.Namespace(c, key.Namespace())
+		if err != nil {
+			return err
+		}
+		// existing entities within the range, such as the ones just saved,
+		// don't prevent the range from being allocated
+		err = datastore.AllocateIDRange(nc, key.Kind(), key.Parent(), key.IntID(), key.IntID())
+		switch err.(type) {
+		case nil, *datastore.KeyRangeCollisionError, *datastore.KeyRangeContentionError:
+		default:
+			return err
+		}
+	}
+	return nil
+}
+
+// GetMulti loads the entities from the datastore
+func (AppEngineBackend) GetMulti(c context.Context, keys []*datastore.Key, dst interface{}) error {
+	return datastore.GetMulti(c, keys, dst)
+}
+
+// DeleteMulti deletes the entities from the datastore
+func (AppEngineBackend) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	return datastore.DeleteMulti(c, keys)
+}
+
+// RunInTransaction runs f in a datastore transaction, which is retried on contention
+func (AppEngineBackend) RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	return datastore.RunInTransaction(c, f, opts)
+}
+
+// CacheGet gob decodes the memcache item into dst
+func (AppEngineBackend) CacheGet(c context.Context, key string, dst interface{}) error {
+	_, err := memcache.Gob.Get(c, key, dst)
+	return err
+}
+
+// CacheSet gob encodes src into memcache
+func (AppEngineBackend) CacheSet(c context.Context, key string, src interface{}, expiration time.Duration) error {
+	return memcache.Gob.Set(c, &memcache.Item{Key: key, Object: src, Expiration: expiration})
+}
+
+// CacheDelete deletes the memcache item
+func (AppEngineBackend) CacheDelete(c context.Context, key string) error {
+	return memcache.Delete(c, key)
+}
+
+// CacheGetMulti fetches the memcache items in a single call and gob decodes
+// each into the matching dst slice element
+func (AppEngineBackend) CacheGetMulti(c context.Context, keys []string, dst interface{}) error {
+	items, err := memcache.GetMulti(c, keys)
+	if err != nil {
+		return err
+	}
+	dv := reflect.ValueOf(dst)
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		item, ok := items[key]
+		if !ok {
+			errs[i], hasErr = memcache.ErrCacheMiss, true
+			continue
+		}
+		err := gob.NewDecoder(bytes.NewReader(item.Value)).Decode(elemPointer(dv.Index(i)))
+		if err != nil {
+			errs[i], hasErr = err, true
+		}
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// CacheSetMulti gob encodes each of the src slice elements into memcache
+func (AppEngineBackend) CacheSetMulti(c context.Context, keys []string, src interface{}, expiration time.Duration) error {
+	sv := reflect.ValueOf(src)
+	items := make([]*memcache.Item, len(keys))
+	for i, key := range keys {
+		items[i] = &memcache.Item{Key: key, Object: sv.Index(i).Interface(), Expiration: expiration}
+	}
+	return memcache.Gob.SetMulti(c, items)
+}
+
+// CacheDeleteMulti deletes the memcache items
+func (AppEngineBackend) CacheDeleteMulti(c context.Context, keys []string) error {
+	return memcache.DeleteMulti(c, keys)
+}
@@ -0,0 +1,419 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// DefaultMaxBodyBytes is the largest request body read by the bind helpers of
+// handlers without a MaxBodyBytes setting
+const DefaultMaxBodyBytes = 1 << 20
+
+// bindError contains the messages of the values that couldn't be bound
+type bindError struct {
+	status int
+	msg    string
+	fields map[string]string
+}
+
+func (e bindError) Error() string {
+	return e.msg
+}
+
+func newBindError(fields map[string]string) bindError {
+	return bindError{status: http.StatusBadRequest, msg: "invalid fields", fields: fields}
+}
+
+// BindJSON decodes the JSON body into dst and validates it, responding with a
+// 400 status, or a 422 status for invalid values, and returning false if it
+// fails.
+//  var input struct {
+//  	Title string `json:"title" validate:"required"`
+//  }
+//  if !h.BindJSON(&input) {
+//  	return
+//  }
+func (h *Handler) BindJSON(dst interface{}) bool {
+	return h.bind(dst, h.decodeJSON)
+}
+
+// BindForm binds the URL encoded form values into the fields of dst with
+// `form` tags and validates it
+//  type input struct {
+//  	Title string   `form:"title" validate:"required"`
+//  	Tags  []string `form:"tags"`
+//  }
+func (h *Handler) BindForm(dst interface{}) bool {
+	return h.bind(dst, h.decodeForm)
+}
+
+// BindMultipart binds the multipart form values, and the files into the
+// *multipart.FileHeader fields, of dst and validates it
+//  type input struct {
+//  	Name  string                `form:"name"`
+//  	Photo *multipart.FileHeader `form:"photo"`
+//  }
+func (h *Handler) BindMultipart(dst interface{}) bool {
+	return h.bind(dst, h.decodeMultipart)
+}
+
+// BindQuery binds the query string values into the fields of dst with `query`
+// tags and validates it
+//  type filter struct {
+//  	Cursor string `query:"cursor"`
+//  	Limit  int    `query:"limit" validate:"max=100"`
+//  }
+func (h *Handler) BindQuery(dst interface{}) bool {
+	return h.bind(dst, h.decodeQuery)
+}
+
+// Decode binds the route's params into the fields of dst with `param` tags,
+// the query string values and the body, according to its content type, and
+// then validates it. The route may be nil.
+//  route := ae.NewRoute(r)
+//  if route.Matches("PUT", "/posts/:key") {
+//  	var input struct {
+//  		Key   *datastore.Key `param:"key"`
+//  		Title string         `json:"title" form:"title"`
+//  	}
+//  	if !h.Decode(&input, &route) {
+//  		return
+//  	}
+//  }
+func (h *Handler) Decode(dst interface{}, route *Route) bool {
+	return h.bind(dst, func(dst interface{}) error {
+		if route != nil {
+			if err := bindValues(dst, "param", routeValues(route), h.Ctx); err != nil {
+				return err
+			}
+		}
+		if err := h.decodeQuery(dst); err != nil {
+			return err
+		}
+		if h.Req.Body == nil || h.Req.ContentLength == 0 {
+			return nil
+		}
+		mediaType, _, _ := mime.ParseMediaType(h.Req.Header.Get("Content-Type"))
+		switch mediaType {
+		case "application/json", "":
+			return h.decodeJSON(dst)
+		case "application/x-www-form-urlencoded":
+			return h.decodeForm(dst)
+		case "multipart/form-data":
+			return h.decodeMultipart(dst)
+		default:
+			return bindError{status: http.StatusUnsupportedMediaType, msg: "unsupported content type " + mediaType}
+		}
+	})
+}
+
+// bind decodes and validates dst, responding with the errors
+func (h *Handler) bind(dst interface{}, decode func(dst interface{}) error) bool {
+	err := decode(dst)
+	if err == nil {
+		return h.Validate(dst)
+	}
+	if e, ok := err.(bindError); ok {
+		h.Error(HTTPError{Status: e.status, Message: e.msg, Fields: e.fields})
+		return false
+	}
+	h.Abort(http.StatusInternalServerError, err)
+	return false
+}
+
+func (h *Handler) maxBodyBytes() int64 {
+	if h.config.MaxBodyBytes > 0 {
+		return h.config.MaxBodyBytes
+	}
+	return DefaultMaxBodyBytes
+}
+
+// body limits the request body to one byte over the max size, for bodies
+// without a content length to be detected as too large
+func (h *Handler) body() io.Reader {
+	return io.LimitReader(h.Req.Body, h.maxBodyBytes()+1)
+}
+
+func (h *Handler) decodeJSON(dst interface{}) error {
+	if h.Req.Body == nil {
+		return bindError{status: http.StatusBadRequest, msg: "missing request body"}
+	}
+	data, err := ioutil.ReadAll(h.body())
+	if err != nil {
+		return bindError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	if int64(len(data)) > h.maxBodyBytes() {
+		return errBodyTooLarge
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if h.config.DisallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	err = dec.Decode(dst)
+	switch e := err.(type) {
+	case nil:
+		return nil
+	case *json.UnmarshalTypeError:
+		field := e.Field
+		if field == "" {
+			field = "body"
+		}
+		return newBindError(map[string]string{field: "must be a " + jsonType(e.Type)})
+	default:
+		if msg := err.Error(); strings.HasPrefix(msg, "json: unknown field ") {
+			field, _ := strconv.Unquote(strings.TrimPrefix(msg, "json: unknown field "))
+			return newBindError(map[string]string{field: "is not allowed"})
+		}
+		return bindError{status: http.StatusBadRequest, msg: "invalid JSON: " + err.Error()}
+	}
+}
+
+var errBodyTooLarge = bindError{status: http.StatusRequestEntityTooLarge, msg: "request body too large"}
+
+func (h *Handler) decodeForm(dst interface{}) error {
+	if h.Req.ContentLength > h.maxBodyBytes() {
+		return errBodyTooLarge
+	}
+	if h.Req.Body != nil {
+		h.Req.Body = http.MaxBytesReader(h.Res, h.Req.Body, h.maxBodyBytes())
+	}
+	if err := h.Req.ParseForm(); err != nil {
+		if strings.Contains(err.Error(), "too large") {
+			return errBodyTooLarge
+		}
+		return bindError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	return bindValues(dst, "form", h.Req.PostForm, h.Ctx)
+}
+
+func (h *Handler) decodeMultipart(dst interface{}) error {
+	if h.Req.ContentLength > h.maxBodyBytes() {
+		return errBodyTooLarge
+	}
+	h.Req.Body = http.MaxBytesReader(h.Res, h.Req.Body, h.maxBodyBytes())
+	if err := h.Req.ParseMultipartForm(h.maxBodyBytes()); err != nil {
+		if strings.Contains(err.Error(), "too large") {
+			return errBodyTooLarge
+		}
+		return bindError{status: http.StatusBadRequest, msg: err.Error()}
+	}
+	if err := bindValues(dst, "form", h.Req.MultipartForm.Value, h.Ctx); err != nil {
+		return err
+	}
+	return bindFiles(dst, h.Req.MultipartForm.File)
+}
+
+func (h *Handler) decodeQuery(dst interface{}) error {
+	return bindValues(dst, "query", h.Req.URL.Query(), h.Ctx)
+}
+
+func routeValues(route *Route) map[string][]string {
+	values := make(map[string][]string)
+	for name, value := range route.params {
+		values[name] = []string{value}
+	}
+	return values
+}
+
+// bindValues sets the fields of dst that have a tag named within the values
+func bindValues(dst interface{}, tagName string, values map[string][]string, c context.Context) error {
+	v := reflect.Indirect(reflect.ValueOf(dst))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("bind: %T is not a struct pointer", dst)
+	}
+	fields := make(map[string]string)
+	eachTaggedField(v, tagName, func(name string, f reflect.Value) {
+		vals, ok := values[name]
+		if !ok || f.Type() == fileHeaderType || f.Type() == fileHeadersType {
+			return
+		}
+		if err := setField(f, vals, c); err != nil {
+			fields[name] = err.Error()
+		}
+	})
+	if len(fields) > 0 {
+		return newBindError(fields)
+	}
+	return nil
+}
+
+var (
+	fileHeaderType  = reflect.TypeOf(&multipart.FileHeader{})
+	fileHeadersType = reflect.TypeOf([]*multipart.FileHeader{})
+)
+
+// bindFiles sets the file header fields of dst
+func bindFiles(dst interface{}, files map[string][]*multipart.FileHeader) error {
+	v := reflect.Indirect(reflect.ValueOf(dst))
+	eachTaggedField(v, "form", func(name string, f reflect.Value) {
+		headers := files[name]
+		switch {
+		case len(headers) == 0:
+		case f.Type() == fileHeaderType:
+			f.Set(reflect.ValueOf(headers[0]))
+		case f.Type() == fileHeadersType:
+			f.Set(reflect.ValueOf(headers))
+		}
+	})
+	return nil
+}
+
+// eachTaggedField calls fn with the settable fields having the tag, including
+// those of embedded structs
+func eachTaggedField(v reflect.Value, tagName string, fn func(name string, f reflect.Value)) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if sf.Anonymous && sf.Type.Kind() == reflect.Struct {
+			eachTaggedField(v.Field(i), tagName, fn)
+			continue
+		}
+		name := strings.Split(sf.Tag.Get(tagName), ",")[0]
+		if name == "" || name == "-" || sf.PkgPath != "" {
+			continue
+		}
+		fn(name, v.Field(i))
+	}
+}
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	keyType  = reflect.TypeOf(&datastore.Key{})
+)
+
+// setField converts the values to the field's type
+func setField(f reflect.Value, values []string, c context.Context) error {
+	if f.Kind() == reflect.Slice && f.Type().Elem().Kind() != reflect.Uint8 {
+		slice := reflect.MakeSlice(f.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(slice.Index(i), value, c); err != nil {
+				return err
+			}
+		}
+		f.Set(slice)
+		return nil
+	}
+	var value string
+	if len(values) > 0 {
+		value = values[0]
+	}
+	return setValue(f, value, c)
+}
+
+func setValue(f reflect.Value, value string, c context.Context) error {
+	switch f.Type() {
+	case timeType:
+		if value == "" {
+			f.Set(reflect.Zero(timeType))
+			return nil
+		}
+		t, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			return fmt.Errorf("must be an RFC 3339 time")
+		}
+		f.Set(reflect.ValueOf(t))
+		return nil
+	case keyType:
+		if value == "" {
+			f.Set(reflect.Zero(keyType))
+			return nil
+		}
+		key, err := DecodeID(c, value)
+		if err != nil {
+			return fmt.Errorf("must be a valid id")
+		}
+		f.Set(reflect.ValueOf(key))
+		return nil
+	}
+
+	switch f.Kind() {
+	case reflect.Ptr:
+		if value == "" {
+			f.Set(reflect.Zero(f.Type()))
+			return nil
+		}
+		p := reflect.New(f.Type().Elem())
+		if err := setValue(p.Elem(), value, c); err != nil {
+			return err
+		}
+		f.Set(p)
+	case reflect.String:
+		f.SetString(value)
+	case reflect.Slice:
+		f.SetBytes([]byte(value))
+	case reflect.Bool:
+		if value == "" || value == "on" {
+			f.SetBool(value == "on")
+			return nil
+		}
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("must be true or false")
+		}
+		f.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value == "" {
+			f.SetInt(0)
+			return nil
+		}
+		n, err := strconv.ParseInt(value, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a whole number")
+		}
+		f.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if value == "" {
+			f.SetUint(0)
+			return nil
+		}
+		n, err := strconv.ParseUint(value, 10, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a positive whole number")
+		}
+		f.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		if value == "" {
+			f.SetFloat(0)
+			return nil
+		}
+		n, err := strconv.ParseFloat(value, f.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("must be a number")
+		}
+		f.SetFloat(n)
+	default:
+		return fmt.Errorf("can't be bound to a %s", f.Type())
+	}
+	return nil
+}
+
+// jsonType returns the JSON name of the Go type
+func jsonType(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.String:
+		return "string"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	default:
+		return "object"
+	}
+}
@@ -0,0 +1,128 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type boundPost struct {
+	Key    *datastore.Key        `param:"key"`
+	Title  string                `json:"title" form:"title" validate:"required"`
+	Views  int                   `json:"views" form:"views"`
+	Tags   []string              `json:"tags" form:"tags"`
+	Draft  bool                  `json:"draft" form:"draft"`
+	Cursor string                `query:"cursor"`
+	Limit  int                   `query:"limit" validate:"max=50"`
+	Photo  *multipart.FileHeader `form:"photo"`
+}
+
+func TestBind(t *testing.T) {
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {}
+	c := NewMemoryContext()
+	key := datastore.NewKey(c, "posts", "", 1, nil)
+
+	var multipartBody bytes.Buffer
+	mw := multipart.NewWriter(&multipartBody)
+	mw.WriteField("title", "foo")
+	fw, _ := mw.CreateFormFile("photo", "photo.png")
+	fw.Write([]byte("png"))
+	mw.Close()
+
+	type test struct {
+		bind        string
+		url         string
+		contentType string
+		body        string
+		max         int64
+		chunked     bool
+		strict      bool
+		status      int
+		field       string
+		expected    boundPost
+	}
+
+	tests := []test{
+		test{bind: "json", url: "/", body: `{"title": "foo", "views": 2, "tags": ["a"]}`, status: 200, expected: boundPost{Title: "foo", Views: 2, Tags: []string{"a"}}},
+		test{bind: "json", url: "/", body: `{"views": 2}`, status: 422, field: "title"},
+		test{bind: "json", url: "/", body: `{"title": "foo", "views": "2"}`, status: 400, field: "views"},
+		test{bind: "json", url: "/", body: `{"title": "foo", "other": 1}`, status: 200, expected: boundPost{Title: "foo"}},
+		test{bind: "json", url: "/", body: `{"title": "foo", "other": 1}`, strict: true, status: 400, field: "other"},
+		test{bind: "json", url: "/", body: `{"title": "foo"`, status: 400},
+		test{bind: "json", url: "/", body: `{"title": "foo bar baz"}`, max: 10, status: 413},
+		test{bind: "form", url: "/", body: "title=foo&views=3&tags=a&tags=b&draft=on", status: 200, expected: boundPost{Title: "foo", Views: 3, Tags: []string{"a", "b"}, Draft: true}},
+		test{bind: "form", url: "/", body: "title=foo&views=x", status: 400, field: "views"},
+		test{bind: "form", url: "/", body: "title=foo+bar+baz", max: 10, status: 413},
+		test{bind: "form", url: "/", body: "title=foo+bar+baz", max: 10, chunked: true, status: 413},
+		test{bind: "query", url: "/?cursor=abc&limit=10", status: 422, field: "title"},
+		test{bind: "decode", url: "/posts/" + EncodeID(key) + "?limit=10", contentType: "application/json", body: `{"title": "foo"}`, status: 200, expected: boundPost{Key: key, Title: "foo", Limit: 10}},
+		test{bind: "decode", url: "/posts/" + EncodeID(key) + "?limit=100", contentType: "application/json", body: `{"title": "foo"}`, status: 422, field: "limit"},
+		test{bind: "decode", url: "/posts/" + EncodeID(key), contentType: "application/x-www-form-urlencoded", body: "title=bar", status: 200, expected: boundPost{Key: key, Title: "bar"}},
+		test{bind: "decode", url: "/posts/bad", contentType: "application/json", body: `{"title": "foo"}`, status: 400, field: "key"},
+		test{bind: "decode", url: "/posts/" + EncodeID(key), contentType: "text/plain", body: "foo", status: 415},
+		test{bind: "multipart", url: "/", contentType: mw.FormDataContentType(), body: multipartBody.String(), status: 200, expected: boundPost{Title: "foo"}},
+	}
+
+	for i, test := range tests {
+		r, _ := http.NewRequest("POST", test.url, strings.NewReader(test.body))
+		r.Header.Set("Accept", "application/json")
+		if test.chunked {
+			r.ContentLength = -1
+		}
+		switch {
+		case test.contentType != "":
+			r.Header.Set("Content-Type", test.contentType)
+		case test.bind == "form":
+			r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		w := httptest.NewRecorder()
+		h := NewHandler(&HandlerConfig{MaxBodyBytes: test.max, DisallowUnknownFields: test.strict})
+		h.Bind(c, w, r)
+
+		var p boundPost
+		var ok bool
+		switch test.bind {
+		case "json":
+			ok = h.BindJSON(&p)
+		case "form":
+			ok = h.BindForm(&p)
+		case "multipart":
+			ok = h.BindMultipart(&p)
+		case "query":
+			ok = h.BindQuery(&p)
+		case "decode":
+			route := NewRoute(r)
+			route.MatchesPath("/posts/:key")
+			ok = h.Decode(&p, &route)
+		}
+
+		if ok != (test.status == 200) || (!ok && w.Code != test.status) {
+			t.Errorf("%d. %s: bound %v with status %d, expected %d: %s", i, test.bind, ok, w.Code, test.status, w.Body)
+			continue
+		}
+		if !ok {
+			var e Problem
+			json.NewDecoder(w.Body).Decode(&e)
+			if test.field != "" && e.Fields[test.field] == "" {
+				t.Errorf("%d. %s: %s not within the errors: %+v", i, test.bind, test.field, e)
+			}
+			continue
+		}
+
+		if p.Title != test.expected.Title || p.Views != test.expected.Views || p.Limit != test.expected.Limit ||
+			p.Draft != test.expected.Draft || strings.Join(p.Tags, ",") != strings.Join(test.expected.Tags, ",") ||
+			!p.Key.Equal(test.expected.Key) {
+			t.Errorf("%d. %s: bound %+v, expected %+v", i, test.bind, p, test.expected)
+		}
+		if test.bind == "multipart" && (p.Photo == nil || p.Photo.Filename != "photo.png") {
+			t.Errorf("%d. photo not bound: %+v", i, p.Photo)
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package ae
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// errPreconditionFailed is returned within transactions when the saved entity
+// doesn't match the request's conditional headers
+var errPreconditionFailed = errors.New("precondition failed")
+
+// EntityETag returns a strong ETag of the model derived from its key and
+// version, or its updated time if it isn't versioned, or an empty string if it
+// has neither.
+//  h.SetETag(ae.EntityETag(post))
+func EntityETag(model interface{}) string {
+	var key *datastore.Key
+	if m, ok := model.(keyGetter); ok {
+		key = m.modelKey()
+	}
+	var version string
+	if v, ok := model.(Versioner); ok && v.ModelVersion() > 0 {
+		version = fmt.Sprintf("v%d", v.ModelVersion())
+	} else if t := entityModTime(model); !t.IsZero() {
+		version = fmt.Sprintf("t%d", t.UnixNano())
+	}
+	if version == "" {
+		return ""
+	}
+
+	hash := md5.New()
+	if key != nil {
+		fmt.Fprint(hash, key.String())
+	}
+	fmt.Fprint(hash, version)
+	return `"` + base64.RawURLEncoding.EncodeToString(hash.Sum(nil)) + `"`
+}
+
+// entityModTime returns the updated time of models embedding Timestamps
+func entityModTime(model interface{}) time.Time {
+	if t, ok := model.(timestamper); ok {
+		return t.timestamps().UpdatedAt
+	}
+	return time.Time{}
+}
+
+type keyGetter interface {
+	modelKey() *datastore.Key
+}
+
+func (m *Model) modelKey() *datastore.Key {
+	return m.Key
+}
+
+// CheckPreconditions evaluates the request's conditional headers against the
+// ETag and Last-Modified headers of the response, responding with a 304 or 412
+// status and returning false if the handler shouldn't continue.
+//  h.SetETag(ae.EntityETag(post))
+//  h.SetLastModified(post.UpdatedAt)
+//  if !h.CheckPreconditions() {
+//  	return
+//  }
+func (h *Handler) CheckPreconditions() bool {
+	switch status := h.preconditionStatus(); status {
+	case http.StatusNotModified:
+		h.Res.Header().Del("Content-Type")
+		h.Res.Header().Del("Content-Length")
+		h.Res.WriteHeader(status)
+		return false
+	case http.StatusPreconditionFailed:
+		http.Error(h.Res, http.StatusText(status), status)
+		return false
+	}
+	return true
+}
+
+// preconditionStatus returns the status of the request's conditional headers,
+// evaluated in the order of RFC 7232 section 6, or 0 if the request can proceed
+func (h *Handler) preconditionStatus() int {
+	etag := h.Res.Header().Get("ETag")
+	modified, err := http.ParseTime(h.Res.Header().Get("Last-Modified"))
+	hasModified := err == nil
+	get := h.Req.Method == "GET" || h.Req.Method == "HEAD"
+
+	if match := h.Req.Header.Get("If-Match"); match != "" {
+		if !etagMatches(match, etag, false) {
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(h.Req.Header.Get("If-Unmodified-Since")); err == nil && hasModified {
+		if modified.Truncate(time.Second).After(since) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if noneMatch := h.Req.Header.Get("If-None-Match"); noneMatch != "" {
+		if etagMatches(noneMatch, etag, true) {
+			if get {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if since, err := http.ParseTime(h.Req.Header.Get("If-Modified-Since")); err == nil && hasModified && get {
+		if !modified.Truncate(time.Second).After(since) {
+			return http.StatusNotModified
+		}
+	}
+	return 0
+}
+
+// etagMatches indicates if the ETag is within the header's list of tags, with
+// weak comparisons ignoring the weak indicator of the tags
+func etagMatches(header, etag string, weak bool) bool {
+	if etag == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	if !weak && strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if !weak && strings.HasPrefix(tag, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package ae
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type conditionalPost struct {
+	Model
+	Timestamps
+}
+
+func TestEntityETag(t *testing.T) {
+	c := NewMemoryContext()
+	key := datastore.NewKey(c, "posts", "", 1, nil)
+	other := datastore.NewKey(c, "posts", "", 2, nil)
+	now := time.Now()
+
+	type test struct {
+		a, b  interface{}
+		equal bool
+	}
+
+	tests := []test{
+		test{a: &conditionalPost{Model: Model{Key: key, Version: 1}}, b: &conditionalPost{Model: Model{Key: key, Version: 1}}, equal: true},
+		test{a: &conditionalPost{Model: Model{Key: key, Version: 1}}, b: &conditionalPost{Model: Model{Key: key, Version: 2}}},
+		test{a: &conditionalPost{Model: Model{Key: key, Version: 1}}, b: &conditionalPost{Model: Model{Key: other, Version: 1}}},
+		test{a: &conditionalPost{Model: Model{Key: key}, Timestamps: Timestamps{UpdatedAt: now}}, b: &conditionalPost{Model: Model{Key: key}, Timestamps: Timestamps{UpdatedAt: now.Add(time.Second)}}},
+	}
+
+	for i, test := range tests {
+		a, b := EntityETag(test.a), EntityETag(test.b)
+		if a == "" || a[0] != '"' || (a == b) != test.equal {
+			t.Errorf("%d. etags %s and %s, expected equal: %v", i, a, b, test.equal)
+		}
+	}
+	if etag := EntityETag(&conditionalPost{Model: Model{Key: key}}); etag != "" {
+		t.Errorf("unversioned model etag %s, expected none", etag)
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	modified := time.Date(2016, 5, 1, 12, 0, 0, 0, time.UTC)
+	before := modified.Add(-time.Hour).Format(http.TimeFormat)
+	after := modified.Add(time.Hour).Format(http.TimeFormat)
+
+	type test struct {
+		method string
+		header string
+		value  string
+		status int
+	}
+
+	tests := []test{
+		test{method: "GET", status: 200},
+		test{method: "GET", header: "If-None-Match", value: `"abc"`, status: 304},
+		test{method: "GET", header: "If-None-Match", value: `W/"abc"`, status: 304},
+		test{method: "GET", header: "If-None-Match", value: `"xyz", "abc"`, status: 304},
+		test{method: "GET", header: "If-None-Match", value: `*`, status: 304},
+		test{method: "GET", header: "If-None-Match", value: `"xyz"`, status: 200},
+		test{method: "HEAD", header: "If-None-Match", value: `"abc"`, status: 304},
+		test{method: "PUT", header: "If-None-Match", value: `"abc"`, status: 412},
+		test{method: "GET", header: "If-Modified-Since", value: after, status: 304},
+		test{method: "GET", header: "If-Modified-Since", value: modified.Format(http.TimeFormat), status: 304},
+		test{method: "GET", header: "If-Modified-Since", value: before, status: 200},
+		test{method: "PUT", header: "If-Modified-Since", value: after, status: 200},
+		test{method: "PUT", header: "If-Match", value: `"abc"`, status: 200},
+		test{method: "PUT", header: "If-Match", value: `W/"abc"`, status: 412},
+		test{method: "PUT", header: "If-Match", value: `"xyz"`, status: 412},
+		test{method: "DELETE", header: "If-Match", value: `*`, status: 200},
+		test{method: "PUT", header: "If-Unmodified-Since", value: after, status: 200},
+		test{method: "PUT", header: "If-Unmodified-Since", value: before, status: 412},
+	}
+
+	for i, test := range tests {
+		r, _ := http.NewRequest(test.method, "/", nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+		w := httptest.NewRecorder()
+		h := DefaultHandler()
+		h.Bind(NewMemoryContext(), w, r)
+		h.SetETag(`"abc"`)
+		h.SetLastModified(modified)
+
+		if ok := h.CheckPreconditions(); ok != (test.status == 200) || w.Code != test.status {
+			t.Errorf("%d. %s %s: %s returned %v with status %d, expected %d", i, test.method, test.header, test.value, ok, w.Code, test.status)
+		}
+	}
+}
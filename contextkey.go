@@ -0,0 +1,7 @@
+package ae
+
+type contextKey string
+
+func (c contextKey) String() string {
+	return "ae-context-key" + string(c)
+}
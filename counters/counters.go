@@ -0,0 +1,190 @@
+package counters
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/chrisolsen/ae"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	configTable = "counterConfigs"
+	shardTable  = "counterShards"
+
+	// DefaultShards is the number of shards of counters that haven't been grown
+	DefaultShards = 20
+)
+
+// Errors
+var (
+	ErrShrinkShards = errors.New("the number of shards can't be reduced")
+)
+
+var configStore = ae.NewStore(configTable)
+
+// config contains the number of shards of a counter
+type config struct {
+	Shards int `datastore:",noindex"`
+}
+
+// shard is one of the entities the counter's total is split across
+type shard struct {
+	Name  string
+	Count int64 `datastore:",noindex"`
+}
+
+func configKey(c context.Context, name string) *datastore.Key {
+	return datastore.NewKey(c, configTable, name, 0, nil)
+}
+
+func shardKey(c context.Context, name string, i int) *datastore.Key {
+	return datastore.NewKey(c, shardTable, fmt.Sprintf("%s-%d", name, i), 0, nil)
+}
+
+func cacheKey(name string) string {
+	return "counter:" + name
+}
+
+// shardCount returns the number of shards of the counter
+func shardCount(c context.Context, name string) (int, error) {
+	var cfg config
+	_, err := configStore.Get(c, configKey(c, name), &cfg)
+	if err == datastore.ErrNoSuchEntity {
+		return DefaultShards, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return cfg.Shards, nil
+}
+
+func shardKeys(c context.Context, name string, count int) []*datastore.Key {
+	keys := make([]*datastore.Key, count)
+	for i := range keys {
+		keys[i] = shardKey(c, name, i)
+	}
+	return keys
+}
+
+// Increment adds one to the counter
+func Increment(c context.Context, name string) error {
+	return IncrementBy(c, name, 1)
+}
+
+// Decrement subtracts one from the counter
+func Decrement(c context.Context, name string) error {
+	return IncrementBy(c, name, -1)
+}
+
+// IncrementBy adds the delta to a randomly chosen shard of the counter, which
+// spreads the writes across the shards to avoid contention
+//  err := counters.IncrementBy(c, "views:"+key.Encode(), 1)
+func IncrementBy(c context.Context, name string, delta int64) error {
+	count, err := shardCount(c, name)
+	if err != nil {
+		return err
+	}
+	b := ae.BackendFromContext(c)
+	key := shardKey(c, name, rand.Intn(count))
+	err = b.RunInTransaction(c, func(tc context.Context) error {
+		s := shard{Name: name}
+		if err := b.Get(tc, key, &s); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		s.Count += delta
+		_, err := b.Put(tc, key, &s)
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+	b.CacheDelete(c, cacheKey(name))
+	return nil
+}
+
+// Get returns the counter's total, which is cached until it is next changed
+func Get(c context.Context, name string) (int64, error) {
+	b := ae.BackendFromContext(c)
+	var total int64
+	if err := b.CacheGet(c, cacheKey(name), &total); err == nil {
+		return total, nil
+	}
+
+	count, err := shardCount(c, name)
+	if err != nil {
+		return 0, err
+	}
+	shards := make([]shard, count)
+	err = b.GetMulti(c, shardKeys(c, name, count), shards)
+	if me, ok := err.(appengine.MultiError); ok {
+		for _, e := range me {
+			if e != nil && e != datastore.ErrNoSuchEntity {
+				return 0, e
+			}
+		}
+	} else if err != nil {
+		return 0, err
+	}
+	for _, s := range shards {
+		total += s.Count
+	}
+	// expires to correct any increments made while the shards were being read
+	b.CacheSet(c, cacheKey(name), total, time.Minute)
+	return total, nil
+}
+
+// GrowShards increases the number of shards of a counter that is under too much
+// contention. The number of shards can't be reduced.
+func GrowShards(c context.Context, name string, shards int) error {
+	b := ae.BackendFromContext(c)
+	key := configKey(c, name)
+	var grown bool
+	err := b.RunInTransaction(c, func(tc context.Context) error {
+		cfg := config{Shards: DefaultShards}
+		if err := b.Get(tc, key, &cfg); err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if shards < cfg.Shards {
+			return ErrShrinkShards
+		}
+		if grown = shards > cfg.Shards; !grown {
+			return nil
+		}
+		_, err := b.Put(tc, key, &config{Shards: shards})
+		return err
+	}, nil)
+	if err != nil {
+		return err
+	}
+	if grown {
+		b.CacheDelete(c, key.Encode())
+	}
+	return nil
+}
+
+// Reset sets the counter back to zero, keeping its number of shards
+func Reset(c context.Context, name string) error {
+	count, err := shardCount(c, name)
+	if err != nil {
+		return err
+	}
+	b := ae.BackendFromContext(c)
+	if err = b.DeleteMulti(c, shardKeys(c, name, count)); err != nil {
+		return err
+	}
+	b.CacheDelete(c, cacheKey(name))
+	return nil
+}
+
+// Delete deletes the counter's shards and configuration
+func Delete(c context.Context, name string) error {
+	if err := Reset(c, name); err != nil {
+		return err
+	}
+	return configStore.Delete(c, configKey(c, name))
+}
@@ -0,0 +1,58 @@
+package counters
+
+import (
+	"testing"
+
+	"github.com/chrisolsen/ae"
+)
+
+func TestCounter(t *testing.T) {
+	c := ae.NewMemoryContext()
+
+	for i := 0; i < 50; i++ {
+		if err := Increment(c, "views"); err != nil {
+			t.Errorf("failed to increment: %v", err)
+			return
+		}
+	}
+	Decrement(c, "views")
+	IncrementBy(c, "views", 10)
+
+	if total, err := Get(c, "views"); err != nil || total != 59 {
+		t.Errorf("expected total of %d, got %d %v", 59, total, err)
+		return
+	}
+
+	// the cached total must be cleared
+	Increment(c, "views")
+	if total, _ := Get(c, "views"); total != 60 {
+		t.Errorf("stale total: %d", total)
+	}
+
+	if err := GrowShards(c, "views", 5); err != ErrShrinkShards {
+		t.Errorf("expected ErrShrinkShards: %v", err)
+	}
+	if err := GrowShards(c, "views", 40); err != nil {
+		t.Errorf("failed to grow shards: %v", err)
+		return
+	}
+	for i := 0; i < 40; i++ {
+		Increment(c, "views")
+	}
+	if total, _ := Get(c, "views"); total != 100 {
+		t.Errorf("expected total of %d after growing, got %d", 100, total)
+	}
+
+	Reset(c, "views")
+	if total, _ := Get(c, "views"); total != 0 {
+		t.Errorf("total not reset: %d", total)
+	}
+	if count, _ := shardCount(c, "views"); count != 40 {
+		t.Errorf("shards not kept on reset: %d", count)
+	}
+
+	Delete(c, "views")
+	if count, _ := shardCount(c, "views"); count != DefaultShards {
+		t.Errorf("config not deleted: %d", count)
+	}
+}
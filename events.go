@@ -0,0 +1,183 @@
+package ae
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/delay"
+)
+
+// Event actions
+const (
+	EventCreated = "created"
+	EventUpdated = "updated"
+	EventDeleted = "deleted"
+)
+
+// Event is published by the Store once a model change has been committed. Old
+// is the saved model prior to the change, which is nil for created events or
+// when the store can't load it, such as for deletes by stores without a Model
+// set. New is nil for deleted events.
+type Event struct {
+	Action string
+	Key    *datastore.Key
+	Old    interface{}
+	New    interface{}
+}
+
+// Subscriber handles the published events
+type Subscriber func(c context.Context, e Event) error
+
+type subscription struct {
+	id    int
+	name  string
+	kinds map[string]bool
+	fn    Subscriber
+}
+
+var (
+	subscriptionsMu sync.RWMutex
+	subscriptions   []subscription
+	lastSubID       int
+)
+
+// delivers the events of the async subscribers within a task
+var deliverEvent = delay.Func("ae-event", func(c context.Context, name string, e Event) error {
+	for _, sub := range subscribers("") {
+		if sub.name == name {
+			return sub.fn(c, e)
+		}
+	}
+	return fmt.Errorf("no async event subscriber named %s", name)
+})
+
+// allows the task queue to be stubbed out within tests
+var callAsync = func(c context.Context, name string, e Event) error {
+	return deliverEvent.Call(c, name, e)
+}
+
+// Subscribe calls fn with the events of the kinds, or of every kind if none are
+// passed, within the request that changed the model. The returned func removes
+// the subscription. Errors are logged rather than returned by the Store, as the
+// change has already been committed.
+//  ae.Subscribe(func(c context.Context, e ae.Event) error {
+//  	return counters.Increment(c, "posts:"+e.Action)
+//  }, "posts")
+func Subscribe(fn Subscriber, kinds ...string) func() {
+	return subscribe("", fn, kinds)
+}
+
+// SubscribeAsync delivers the events to fn within a task queue task, to avoid
+// slow subscribers holding up the request. The name identifies the subscriber
+// within the task, so must be unique and subscribed to on every instance,
+// such as within an init func. The models must be registered with gob.
+//  func init() {
+//  	gob.Register(&Post{})
+//  	ae.SubscribeAsync("post-webhooks", sendWebhooks, "posts")
+//  }
+func SubscribeAsync(name string, fn Subscriber, kinds ...string) func() {
+	if name == "" {
+		panic("ae: async event subscribers must be named")
+	}
+	return subscribe(name, fn, kinds)
+}
+
+func subscribe(name string, fn Subscriber, kinds []string) func() {
+	subscriptionsMu.Lock()
+	defer subscriptionsMu.Unlock()
+	lastSubID++
+	sub := subscription{id: lastSubID, name: name, fn: fn}
+	if len(kinds) > 0 {
+		sub.kinds = make(map[string]bool)
+		for _, kind := range kinds {
+			sub.kinds[kind] = true
+		}
+	}
+	subscriptions = append(subscriptions, sub)
+
+	return func() {
+		subscriptionsMu.Lock()
+		defer subscriptionsMu.Unlock()
+		for i, s := range subscriptions {
+			if s.id == sub.id {
+				subscriptions = append(subscriptions[:i], subscriptions[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// subscribers returns the subscriptions of the kind, or all of them if the
+// kind is empty
+func subscribers(kind string) []subscription {
+	subscriptionsMu.RLock()
+	defer subscriptionsMu.RUnlock()
+	var subs []subscription
+	for _, sub := range subscriptions {
+		if kind == "" || sub.kinds == nil || sub.kinds[kind] {
+			subs = append(subs, sub)
+		}
+	}
+	return subs
+}
+
+// hasSubscribers indicates if the events of the store's kind are subscribed
+// to, in which case the previous models are loaded for the events
+func (s Store) hasSubscribers() bool {
+	return len(subscribers(s.TableName)) > 0
+}
+
+// publish delivers the event to the subscribers of the key's kind once the
+// transaction of the context has committed, logging the errors of the
+// subscribers or of adding the tasks, since the change has been committed
+func (s Store) publish(c context.Context, action string, key *datastore.Key, old, model interface{}) {
+	subs := subscribers(key.Kind())
+	if len(subs) == 0 {
+		return
+	}
+	e := Event{Action: action, Key: key, Old: old, New: model}
+	afterCommit(c, func(c context.Context) {
+		for _, sub := range subs {
+			var err error
+			if sub.name != "" {
+				err = callAsync(c, sub.name, e)
+			} else {
+				err = sub.fn(c, e)
+			}
+			if err != nil {
+				logStoreError(c, "publishing %s event of %v: %v", action, key, err)
+			}
+		}
+	})
+}
+
+// loadPrevious loads the saved model of the key, for the events, into a new
+// value of the type, returning nil if there are no subscribers or it doesn't
+// exist
+func (s Store) loadPrevious(c context.Context, key *datastore.Key, typ reflect.Type) interface{} {
+	if key == nil || key.Incomplete() || typ == nil || !s.hasSubscribers() {
+		return nil
+	}
+	if typ.Kind() == reflect.Ptr {
+		typ = typ.Elem()
+	}
+	old := reflect.New(typ).Interface()
+	if err := s.backend(c).Get(c, key, old); err != nil && !isFieldMismatch(err) {
+		return nil
+	}
+	return old
+}
+
+// copyModel returns a shallow copy of the model for the events
+func copyModel(model interface{}) interface{} {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Ptr {
+		return model
+	}
+	cp := reflect.New(v.Elem().Type())
+	cp.Elem().Set(v.Elem())
+	return cp.Interface()
+}
@@ -1,6 +1,7 @@
 package ae
 
 import (
+	"bytes"
 	"crypto/md5"
 	"encoding/base64"
 	"encoding/json"
@@ -15,8 +16,6 @@ import (
 
 	"github.com/chrisolsen/ae/flash"
 	"golang.org/x/net/context"
-	"google.golang.org/appengine"
-	"google.golang.org/appengine/log"
 )
 
 type handlerError struct {
@@ -46,7 +45,7 @@ type Handler struct {
 	Res http.ResponseWriter
 
 	config          HandlerConfig
-	templates       map[string]*template.Template
+	templates       *Templates
 	templateHelpers map[string]interface{}
 }
 
@@ -60,6 +59,15 @@ type HandlerConfig struct {
 	ViewPath string
 	// ???
 	ParentLayoutName string
+	// Largest request body, in bytes, read by the bind helpers
+	MaxBodyBytes int64
+	// Rejects JSON bodies containing fields that aren't within the struct
+	DisallowUnknownFields bool
+	// Registry of parsed templates shared by the handlers. Its functions are
+	// used rather than the handlers' helpers. If it isn't set, the handlers with
+	// the same paths share a registry that is parsed when the first of them is
+	// created, with the views using helpers parsed on their first use.
+	Templates *Templates
 }
 
 var defaultHandlerConfig = HandlerConfig{
@@ -76,6 +84,16 @@ var defaultHandlerConfig = HandlerConfig{
 //  	})}
 //  }
 func NewHandler(c *HandlerConfig) Handler {
+	setConfigDefaults(c)
+	b := Handler{config: *c} // copy the passed in pointer
+	b.templates = c.Templates
+	if b.templates == nil {
+		b.templates = sharedRegistry(*c)
+	}
+	return b
+}
+
+func setConfigDefaults(c *HandlerConfig) {
 	if c.LayoutFileName == "" {
 		c.LayoutFileName = defaultHandlerConfig.LayoutFileName
 	}
@@ -88,9 +106,6 @@ func NewHandler(c *HandlerConfig) Handler {
 	if c.ViewPath == "" {
 		c.ViewPath = defaultHandlerConfig.ViewPath
 	}
-	b := Handler{config: *c} // copy the passed in pointer
-	b.templates = make(map[string]*template.Template)
-	return b
 }
 
 // DefaultHandler uses the default config settings
@@ -200,6 +215,21 @@ func (h *Handler) ToJSONWithStatus(data interface{}, status int) {
 	h.ToJSON(data)
 }
 
+// Validate checks the data against its `validate` tags and Valid method,
+// responding with the problem details of a 422 status, containing the messages
+// of the invalid fields, when it isn't valid.
+//  if !h.Validate(&input) {
+//  	return
+//  }
+func (h *Handler) Validate(data interface{}) bool {
+	err := validate(data)
+	if err == nil {
+		return true
+	}
+	h.Error(err)
+	return false
+}
+
 // SendStatus writes the passed in status to the response without any data
 func (h *Handler) SendStatus(status int) {
 	h.Res.WriteHeader(status)
@@ -221,39 +251,10 @@ func (h *Handler) SetHeader(name, value string) {
 }
 
 // Abort is called when pre-maturally exiting from a handler function due to an
-// error. A detailed error is delivered to the client and logged to provide the
-// details required to identify the issue.
+// error. The error's problem details are delivered to the client, while the
+// details required to identify the issue are only logged.
 func (h *Handler) Abort(statusCode int, err error) {
-	c, cancel := context.WithCancel(h.Ctx)
-	defer cancel()
-
-	// testapp is the name given to all apps when being tested
-	var isTest = appengine.AppID(c) == "testapp"
-
-	hErr := &handlerError{
-		URL:        h.Req.URL,
-		Method:     h.Req.Method,
-		StatusCode: statusCode,
-		AppVersion: appengine.AppID(c),
-		RequestID:  appengine.RequestID(c),
-	}
-	if err != nil {
-		hErr.Err = err.Error()
-	}
-
-	if !isTest {
-		hErr.InstanceID = appengine.InstanceID()
-		hErr.VersionID = appengine.VersionID(c)
-		hErr.ModuleName = appengine.ModuleName(c)
-	}
-
-	// log method to appengine log
-	log.Errorf(c, hErr.Error())
-
-	h.Res.WriteHeader(statusCode)
-	if strings.Index(h.Req.Header.Get("Accept"), "application/json") >= 0 {
-		json.NewEncoder(h.Res).Encode(hErr)
-	}
+	h.respondError(statusCode, err)
 }
 
 // Redirect is a simple wrapper around the core http method
@@ -288,54 +289,67 @@ type RenderOptions struct {
 
 // RenderTemplate renders the template without any layout
 func (h *Handler) RenderTemplate(tmplPath string, data interface{}, opts RenderOptions) {
-	name := strings.TrimPrefix(tmplPath, "/")
-	tmpl := h.templates[name]
-	if tmpl == nil {
-		t := template.New(name)
-		if opts.FuncMap != nil {
-			t.Funcs(opts.FuncMap)
-		}
-		var views []string
-		if opts.Parents != nil {
-			for _, p := range opts.Parents {
-				views = append(views, h.fileNameWithExt(p))
-			}
-		} else {
-			views = make([]string, 0)
-		}
+	if err := h.renderTemplate(tmplPath, data, opts); err != nil {
+		h.Abort(http.StatusInternalServerError, err)
+	}
+}
+
+// renderTemplate renders the template, returning the errors of parsing or
+// executing it before anything is written to the response
+func (h *Handler) renderTemplate(tmplPath string, data interface{}, opts RenderOptions) error {
+	tmpl, err := h.registry().Lookup(tmplPath, opts.Parents, opts.FuncMap)
+	if err != nil {
+		return err
+	}
+
+	// executed before the status is sent, for errors to be reported
+	var buf bytes.Buffer
+	if opts.Name != "" {
+		err = tmpl.ExecuteTemplate(&buf, opts.Name, data)
+	} else {
+		err = tmpl.Execute(&buf, data)
+	}
+	if err != nil {
+		return err
+	}
 
-		views = append(views, filepath.Join(h.config.ViewPath, h.fileNameWithExt(name)))
-		tmpl = template.Must(t.ParseFiles(views...))
-		h.templates[name] = tmpl
+	if h.Res.Header().Get("Content-Type") == "" {
+		h.Res.Header().Set("Content-Type", "text/html; charset=utf-8")
 	}
 	if opts.Status != 0 {
 		h.Res.WriteHeader(opts.Status)
 	} else {
 		h.Res.WriteHeader(http.StatusOK)
 	}
+	buf.WriteTo(h.Res)
+	return nil
+}
 
-	var renderErr error
-	if opts.Name != "" {
-		renderErr = tmpl.ExecuteTemplate(h.Res, opts.Name, data)
-	} else {
-		renderErr = tmpl.Execute(h.Res, data)
-	}
-	if renderErr != nil {
-		panic(renderErr)
+// registry returns the handler's templates, which are shared with the other
+// handlers that weren't created by NewHandler
+func (h *Handler) registry() *Templates {
+	if h.templates == nil {
+		h.templates = sharedRegistry(h.config)
 	}
+	return h.templates
 }
 
-// SetLastModified sets the Last-Modified header in the RFC1123 time format
+// SetLastModified sets the Last-Modified header in the http time format
 func (h *Handler) SetLastModified(t time.Time) {
-	h.Res.Header().Set("Last-Modified", t.Format(time.RFC1123))
+	h.Res.Header().Set("Last-Modified", t.UTC().Format(http.TimeFormat))
 }
 
-// SetETag sets the etag with the md5 value
+// SetETag sets the etag with the md5 value, or as is if it's already a quoted
+// entity tag, such as those of EntityETag
 func (h *Handler) SetETag(val interface{}) {
 	var str string
 	switch val.(type) {
 	case string:
 		str = val.(string)
+		if strings.HasPrefix(str, `"`) || strings.HasPrefix(str, `W/"`) {
+			h.Res.Header().Set("ETag", str)
+			return
+		}
 	case time.Time:
 		str = val.(time.Time).Format(time.RFC1123)
 	case fmt.Stringer:
@@ -347,7 +361,7 @@ func (h *Handler) SetETag(val interface{}) {
 	hash := md5.New()
 	io.WriteString(hash, str)
 	etag := base64.StdEncoding.EncodeToString(hash.Sum(nil))
-	h.Res.Header().Set("ETag", etag)
+	h.Res.Header().Set("ETag", `"`+etag+`"`)
 }
 
 // SetExpires sets the Expires response header with a properly formatted time value
@@ -360,16 +374,6 @@ func (h *Handler) SetExpiresIn(d time.Duration) {
 	h.Res.Header().Set("Expires", time.Now().Add(d).Format(time.RFC1123))
 }
 
-func (h *Handler) fileNameWithExt(name string) string {
-	var ext string
-	if strings.Index(name, ".") > 0 {
-		ext = ""
-	} else {
-		ext = ".html"
-	}
-	return fmt.Sprintf("%s%s", name, ext)
-}
-
 // SetFlash sets a temporary message into a response cookie, that after
 // being viewed will be removed, to prevent it from being viewed again.
 func (h *Handler) SetFlash(msg string, args ...interface{}) {
@@ -394,6 +398,9 @@ type ServerError struct {
 	Message, Details, StackTrace string
 }
 
+// the view rendered by RenderError
+const errorTemplate = "error.html"
+
 var defaultServerErrMsgs = map[int]string{
 	500: "Something bad happened!",
 	404: "Oops! Page not found",
@@ -403,6 +410,11 @@ var defaultServerErrMsgs = map[int]string{
 
 // RenderError will render a the file that corresponds to the status code ex. http.InternalServerError will render 500.html
 func (h *Handler) RenderError(status int, err *ServerError) {
+	h.RenderTemplate(errorTemplate, errorPage(status, err), RenderOptions{Status: status})
+}
+
+// errorPage sets the status and default message of the error page's details
+func errorPage(status int, err *ServerError) *ServerError {
 	if err == nil {
 		err = &ServerError{}
 	}
@@ -410,5 +422,5 @@ func (h *Handler) RenderError(status int, err *ServerError) {
 	if err.Message == "" {
 		err.Message = defaultServerErrMsgs[status]
 	}
-	h.RenderTemplate("error.html", err, RenderOptions{Status: status})
+	return err
 }
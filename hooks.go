@@ -0,0 +1,133 @@
+package ae
+
+import (
+	"reflect"
+
+	validation "github.com/chrisolsen/ae/validate"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Validator is implemented by models that validate their data before being
+// saved by the Store, after the rules of their `validate` tags are checked.
+// Errors that aren't already an ErrModelValidation are converted to one.
+type Validator interface {
+	Valid() error
+}
+
+// BeforeCreator is implemented by models that need to run code before being
+// created by the Store
+type BeforeCreator interface {
+	BeforeCreate(c context.Context) error
+}
+
+// BeforeUpdater is implemented by models that need to run code before being
+// updated by the Store
+type BeforeUpdater interface {
+	BeforeUpdate(c context.Context) error
+}
+
+// AfterLoader is implemented by models that need to run code after being
+// loaded by the Store, whether from the cache or the datastore
+type AfterLoader interface {
+	AfterLoad(c context.Context) error
+}
+
+// BeforeDeleter is implemented by models that need to run code before being
+// deleted by the Store. The hook is only called by stores that have their
+// Model set, since the model has to be loaded before the delete.
+type BeforeDeleter interface {
+	BeforeDelete(c context.Context) error
+}
+
+// keySetter allows the Store to set the key of models embedding Model
+type keySetter interface {
+	setKey(key *datastore.Key)
+}
+
+func (m *Model) setKey(key *datastore.Key) {
+	m.Key, m.ID = key, EncodeID(key)
+}
+
+// beforeSave sets the timestamps and calls the create or update hook followed
+// by the validation
+func beforeSave(c context.Context, data interface{}, create bool) error {
+	if t, ok := data.(timestamper); ok {
+		ts, now := t.timestamps(), timeNow()
+		if create || ts.CreatedAt.IsZero() {
+			ts.CreatedAt = now
+		}
+		ts.UpdatedAt = now
+	}
+	if create {
+		if h, ok := data.(BeforeCreator); ok {
+			if err := h.BeforeCreate(c); err != nil {
+				return err
+			}
+		}
+	} else {
+		if h, ok := data.(BeforeUpdater); ok {
+			if err := h.BeforeUpdate(c); err != nil {
+				return err
+			}
+		}
+	}
+	return validate(data)
+}
+
+// validate checks the model's `validate` tags followed by its Valid method
+func validate(data interface{}) error {
+	if validation.HasRules(data) {
+		switch err := validation.Struct(data).(type) {
+		case nil:
+		case validation.Errors:
+			return validationError(err)
+		default:
+			return err
+		}
+	}
+	v, ok := data.(Validator)
+	if !ok {
+		return nil
+	}
+	if err := v.Valid(); err != nil {
+		return validationError(err)
+	}
+	return nil
+}
+
+// validationError converts the error into an ErrModelValidation
+func validationError(err error) error {
+	switch err := err.(type) {
+	case ErrModelValidation:
+		return err
+	case validation.Errors:
+		return ErrModelValidation{Message: err.Error(), Fields: err}
+	default:
+		return NewValidationError(err.Error())
+	}
+}
+
+// afterLoad sets the model's key and calls the load hook
+func afterLoad(c context.Context, key *datastore.Key, dst interface{}) error {
+	if m, ok := dst.(keySetter); ok {
+		m.setKey(key)
+	}
+	if h, ok := dst.(AfterLoader); ok {
+		return h.AfterLoad(c)
+	}
+	return nil
+}
+
+// afterLoadSlice calls afterLoad on each of the slice elements from the start index
+func afterLoadSlice(c context.Context, keys []*datastore.Key, slice reflect.Value, start int) error {
+	for i, key := range keys {
+		if start+i >= slice.Len() {
+			break
+		}
+		if err := afterLoad(c, key, elemPointer(slice.Index(start+i))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
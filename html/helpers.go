@@ -33,25 +33,21 @@ func Add(a, b int) int {
 	return a + b
 }
 
-// EncodeKey encodes a datastore key
+// EncodeKey returns the public ID of a datastore key
 func EncodeKey(data interface{}) string {
 	switch data.(type) {
 	case ae.Model:
-		return data.(ae.Model).Key.Encode()
+		return ae.EncodeID(data.(ae.Model).Key)
 	case *datastore.Key:
-		key := data.(*datastore.Key)
-		if key == nil {
-			return ""
-		}
-		return key.Encode()
+		return ae.EncodeID(data.(*datastore.Key))
 	default:
 		return ""
 	}
 }
 
-// EncodeParentKey encodes the key's parent
+// EncodeParentKey returns the public ID of the key's parent
 func EncodeParentKey(key *datastore.Key) string {
-	return key.Parent().Encode()
+	return ae.EncodeID(key.Parent())
 }
 
 // Checked returns the checked attribute for positive values.
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"html/template"
 	"strings"
+
+	"github.com/chrisolsen/ae"
+	"github.com/chrisolsen/ae/validate"
 )
 
 // Errors allows multiple errors to be contained within a single error, which simplifies
@@ -63,3 +66,25 @@ func ToErrorList(in interface{}) template.HTML {
 	out := fmt.Sprintf(`<ul class="errors">%s</ul>`, strings.Join(lis, "\n"))
 	return template.HTML(out)
 }
+
+// FieldError returns the validation message of the field, from either a
+// validate.Errors or an ae.ErrModelValidation, to be shown alongside the
+// form's inputs.
+//  <input name="email" value="{{.Input.Email}}">
+//  <span class="error">{{fieldError .Error "email"}}</span>
+func FieldError(err interface{}, field string) string {
+	switch err := err.(type) {
+	case validate.Errors:
+		return err[field]
+	case ae.ErrModelValidation:
+		return err.Fields[field]
+	default:
+		return ""
+	}
+}
+
+// HasFieldError indicates if the field is invalid
+//  <div class="field {{if hasFieldError .Error "email"}}invalid{{end}}">
+func HasFieldError(err interface{}, field string) bool {
+	return FieldError(err, field) != ""
+}
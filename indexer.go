@@ -0,0 +1,37 @@
+package ae
+
+import (
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Indexer keeps a secondary index, such as a search index, in sync with the
+// models saved and deleted through a Store. Index is called once a model has
+// been saved or restored, and Unindex once it has been deleted. Their errors are
+// logged rather than returned by the Store, as the change has already been
+// committed.
+//  s := ae.Store{TableName: "posts", Indexers: []ae.Indexer{search.NewIndex("posts", "Title", "Body")}}
+type Indexer interface {
+	Index(c context.Context, key *datastore.Key, model interface{}) error
+	Unindex(c context.Context, key *datastore.Key) error
+}
+
+func (s Store) index(c context.Context, key *datastore.Key, model interface{}) {
+	afterCommit(c, func(c context.Context) {
+		for _, x := range s.Indexers {
+			if err := x.Index(c, key, model); err != nil {
+				logStoreError(c, "indexing %v: %v", key, err)
+			}
+		}
+	})
+}
+
+func (s Store) unindex(c context.Context, key *datastore.Key) {
+	afterCommit(c, func(c context.Context) {
+		for _, x := range s.Indexers {
+			if err := x.Unindex(c, key); err != nil {
+				logStoreError(c, "unindexing %v: %v", key, err)
+			}
+		}
+	})
+}
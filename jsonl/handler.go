@@ -0,0 +1,49 @@
+package jsonl
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/user"
+)
+
+// Handle exports the entities of the `kind` form value on GET requests, and
+// imports the JSON lines request body on POST requests. Only admins are
+// allowed access.
+//  router.Get("/admin/export", q.HandleFunc(jsonl.Handle))
+//  router.Post("/admin/import", q.HandleFunc(jsonl.Handle))
+func Handle(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if !user.IsAdmin(c) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		kind := r.FormValue("kind")
+		if kind == "" {
+			http.Error(w, "missing kind", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", kind+".jsonl"))
+		// the status has already been sent, so errors can only be logged
+		if _, err := Export(c, w, kind); err != nil {
+			log.Errorf(c, "exporting %s: %v", kind, err)
+		}
+	case http.MethodPost:
+		count, err := Import(c, r.Body)
+		if err != nil {
+			log.Errorf(c, "importing: %v", err)
+			http.Error(w, fmt.Sprintf("%d imported before error: %v", count, err), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"imported": count})
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
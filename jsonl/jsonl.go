@@ -0,0 +1,304 @@
+package jsonl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/chrisolsen/ae"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// number of entities read or written per datastore call
+const batchSize = 500
+
+// Errors
+var (
+	ErrMissingKey = errors.New("entity key missing")
+)
+
+// entity is the JSON line representation of an entity, with the property types
+// included to allow for them to be restored exactly
+//  {"key":{"path":[{"kind":"posts","id":1},{"kind":"tags","id":2}]},"parent":{"path":[{"kind":"posts","id":1}]},"properties":[{"name":"Value","type":"string","value":"foo"}]}
+type entity struct {
+	Key        *keyJSON   `json:"key"`
+	Parent     *keyJSON   `json:"parent,omitempty"`
+	Properties []property `json:"properties"`
+}
+
+type keyJSON struct {
+	Namespace string     `json:"namespace,omitempty"`
+	Path      []pathElem `json:"path"`
+}
+
+type pathElem struct {
+	Kind string `json:"kind"`
+	ID   int64  `json:"id,omitempty"`
+	Name string `json:"name,omitempty"`
+}
+
+type property struct {
+	Name     string          `json:"name"`
+	Type     string          `json:"type"`
+	Value    json.RawMessage `json:"value"`
+	NoIndex  bool            `json:"noIndex,omitempty"`
+	Multiple bool            `json:"multiple,omitempty"`
+}
+
+// Export writes every entity of the kind, within the context's namespace, as
+// a line of JSON, returning the number of entities written
+//  n, err := jsonl.Export(c, w, "accounts")
+func Export(c context.Context, w io.Writer, kind string) (int, error) {
+	b := ae.BackendFromContext(c)
+	enc := json.NewEncoder(w)
+
+	var count int
+	var cursor string
+	for {
+		var entities []datastore.PropertyList
+		q := ae.NewQuery(kind).Limit(batchSize).Start(cursor)
+		keys, next, err := b.GetPage(c, q, &entities)
+		if err != nil {
+			return count, err
+		}
+		for i, key := range keys {
+			e, err := encodeEntity(key, entities[i])
+			if err != nil {
+				return count, fmt.Errorf("encoding %v: %v", key, err)
+			}
+			if err = enc.Encode(e); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(keys) < batchSize {
+			return count, nil
+		}
+		cursor = next
+	}
+}
+
+// Import saves each of the JSON lines entities with their exported keys,
+// replacing any existing entities, and returns the number of entities saved.
+// The imported ids are reserved for them to not be allocated to new entities.
+//  n, err := jsonl.Import(c, r.Body)
+func Import(c context.Context, r io.Reader) (int, error) {
+	b := ae.BackendFromContext(c)
+	br := bufio.NewReader(r)
+
+	var count, line int
+	var keys []*datastore.Key
+	var entities []datastore.PropertyList
+	save := func() error {
+		if len(keys) == 0 {
+			return nil
+		}
+		if _, err := b.PutMulti(c, keys, entities); err != nil {
+			return err
+		}
+		if r, ok := b.(ae.IDReserver); ok {
+			if err := r.ReserveIDs(c, keys); err != nil {
+				return err
+			}
+		}
+		encoded := make([]string, len(keys))
+		for i, key := range keys {
+			encoded[i] = key.Encode()
+		}
+		b.CacheDeleteMulti(c, encoded)
+		count += len(keys)
+		keys, entities = nil, nil
+		return nil
+	}
+
+	for {
+		data, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return count, err
+		}
+		line++
+		if data = bytes.TrimSpace(data); len(data) > 0 {
+			key, props, decodeErr := decodeEntity(c, data)
+			if decodeErr != nil {
+				return count, fmt.Errorf("line %d: %v", line, decodeErr)
+			}
+			keys = append(keys, key)
+			entities = append(entities, props)
+		}
+		if len(keys) == batchSize || err == io.EOF {
+			if saveErr := save(); saveErr != nil {
+				return count, saveErr
+			}
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+	}
+}
+
+func encodeEntity(key *datastore.Key, props datastore.PropertyList) (*entity, error) {
+	e := entity{Key: encodeKey(key), Parent: encodeKey(key.Parent())}
+	for _, p := range props {
+		typ, value, err := encodeValue(p.Value)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %v", p.Name, err)
+		}
+		e.Properties = append(e.Properties, property{
+			Name:     p.Name,
+			Type:     typ,
+			Value:    value,
+			NoIndex:  p.NoIndex,
+			Multiple: p.Multiple,
+		})
+	}
+	return &e, nil
+}
+
+func decodeEntity(c context.Context, data []byte) (*datastore.Key, datastore.PropertyList, error) {
+	var e entity
+	if err := json.Unmarshal(data, &e); err != nil {
+		return nil, nil, err
+	}
+	key, err := decodeKey(c, e.Key)
+	if err != nil {
+		return nil, nil, err
+	}
+	if key == nil {
+		return nil, nil, ErrMissingKey
+	}
+
+	props := make(datastore.PropertyList, len(e.Properties))
+	for i, p := range e.Properties {
+		value, err := decodeValue(c, p.Type, p.Value)
+		if err != nil {
+			return nil, nil, fmt.Errorf("property %s: %v", p.Name, err)
+		}
+		props[i] = datastore.Property{Name: p.Name, Value: value, NoIndex: p.NoIndex, Multiple: p.Multiple}
+	}
+	return key, props, nil
+}
+
+func encodeKey(key *datastore.Key) *keyJSON {
+	if key == nil {
+		return nil
+	}
+	k := keyJSON{Namespace: key.Namespace()}
+	for ; key != nil; key = key.Parent() {
+		elem := pathElem{Kind: key.Kind(), ID: key.IntID(), Name: key.StringID()}
+		k.Path = append([]pathElem{elem}, k.Path...)
+	}
+	return &k
+}
+
+// decodeKey rebuilds the key within its namespace, returning nil for nil keys
+func decodeKey(c context.Context, k *keyJSON) (*datastore.Key, error) {
+	if k == nil {
+		return nil, nil
+	}
+	nc, err := appengine.Namespace(c, k.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	var key *datastore.Key
+	for _, elem := range k.Path {
+		if elem.ID == 0 && elem.Name == "" {
+			return nil, fmt.Errorf("incomplete key path element: %s", elem.Kind)
+		}
+		key = datastore.NewKey(nc, elem.Kind, elem.Name, elem.ID, key)
+	}
+	return key, nil
+}
+
+// encodeValue returns the type name and JSON value of the property value
+func encodeValue(v interface{}) (string, json.RawMessage, error) {
+	var typ string
+	switch v := v.(type) {
+	case nil:
+		typ = "null"
+	case int64:
+		typ = "int"
+	case bool:
+		typ = "bool"
+	case string:
+		typ = "string"
+	case float64:
+		typ = "float"
+	case *datastore.Key:
+		typ = "key"
+		data, err := json.Marshal(encodeKey(v))
+		return typ, data, err
+	case time.Time:
+		typ = "time"
+	case []byte:
+		typ = "blob"
+	case datastore.ByteString:
+		typ = "bytestring"
+		data, err := json.Marshal([]byte(v))
+		return typ, data, err
+	case appengine.BlobKey:
+		typ = "blobkey"
+	case appengine.GeoPoint:
+		typ = "geopoint"
+	default:
+		return "", nil, fmt.Errorf("unsupported type %T", v)
+	}
+	data, err := json.Marshal(v)
+	return typ, data, err
+}
+
+func decodeValue(c context.Context, typ string, data json.RawMessage) (interface{}, error) {
+	var err error
+	switch typ {
+	case "null":
+		return nil, nil
+	case "int":
+		var v int64
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "bool":
+		var v bool
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "string":
+		var v string
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "float":
+		var v float64
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "key":
+		var k *keyJSON
+		if err = json.Unmarshal(data, &k); err != nil {
+			return nil, err
+		}
+		return decodeKey(c, k)
+	case "time":
+		var v time.Time
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "blob":
+		var v []byte
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "bytestring":
+		var v []byte
+		err = json.Unmarshal(data, &v)
+		return datastore.ByteString(v), err
+	case "blobkey":
+		var v appengine.BlobKey
+		err = json.Unmarshal(data, &v)
+		return v, err
+	case "geopoint":
+		var v appengine.GeoPoint
+		err = json.Unmarshal(data, &v)
+		return v, err
+	}
+	return nil, fmt.Errorf("unsupported type %s", typ)
+}
@@ -0,0 +1,101 @@
+package jsonl
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/chrisolsen/ae"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+type post struct {
+	Title     string
+	Views     int64
+	Rating    float64
+	Published bool
+	Tags      []string
+	Author    *datastore.Key
+	Created   time.Time
+	Body      []byte
+	Location  appengine.GeoPoint
+}
+
+func TestExportImport(t *testing.T) {
+	c := ae.NewMemoryContext()
+	b := ae.BackendFromContext(c)
+
+	accountKey := datastore.NewKey(c, "accounts", "jim", 0, nil)
+	posts := []post{
+		post{Title: "foo", Views: 1 << 40, Rating: 4.5, Published: true, Tags: []string{"a", "b"}, Author: accountKey},
+		post{Title: "bar", Created: time.Date(2016, 1, 2, 3, 4, 5, 0, time.UTC), Body: []byte("body"), Location: appengine.GeoPoint{Lat: 1, Lng: 2}},
+	}
+	keys := []*datastore.Key{
+		datastore.NewKey(c, "posts", "", 10, accountKey),
+		datastore.NewKey(c, "posts", "", 11, nil),
+	}
+	b.PutMulti(c, keys, posts)
+
+	var buf bytes.Buffer
+	count, err := Export(c, &buf, "posts")
+	if err != nil || count != 2 {
+		t.Errorf("failed to export: %d %v", count, err)
+		return
+	}
+	if lines := strings.Split(strings.TrimSpace(buf.String()), "\n"); len(lines) != 2 {
+		t.Errorf("%d lines exported, expected %d", len(lines), 2)
+		return
+	}
+
+	c2 := ae.NewMemoryContext()
+	count, err = Import(c2, &buf)
+	if err != nil || count != 2 {
+		t.Errorf("failed to import: %d %v", count, err)
+		return
+	}
+
+	imported := make([]post, len(keys))
+	if err = ae.BackendFromContext(c2).GetMulti(c2, keys, imported); err != nil {
+		t.Errorf("failed to get imported: %v", err)
+		return
+	}
+	for i := range posts {
+		if !reflect.DeepEqual(posts[i], imported[i]) {
+			t.Errorf("imported mismatch: %+v <=> %+v", posts[i], imported[i])
+		}
+	}
+
+	key, err := ae.NewStore("posts").Create(c2, &post{Title: "new"}, nil)
+	if err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+	if key.IntID() <= keys[1].IntID() {
+		t.Errorf("allocated id %d, expected it to be past the imported %d", key.IntID(), keys[1].IntID())
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	c := ae.NewMemoryContext()
+
+	type test struct {
+		name  string
+		input string
+	}
+
+	tests := []test{
+		test{name: "invalid json", input: `{"key":`},
+		test{name: "missing key", input: `{"properties":[]}`},
+		test{name: "incomplete key", input: `{"key":{"path":[{"kind":"posts"}]}}`},
+		test{name: "unknown type", input: `{"key":{"path":[{"kind":"posts","id":1}]},"properties":[{"name":"A","type":"foo","value":1}]}`},
+	}
+
+	for _, test := range tests {
+		if _, err := Import(c, strings.NewReader(test.input)); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
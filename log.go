@@ -11,3 +11,9 @@ func LogError(c context.Context, msg string, err error) error {
 	log.Errorf(c, "%s: %s", msg, err.Error())
 	return errors.New(msg)
 }
+
+// logStoreError logs the failures that occur after the Store's writes have been
+// committed, which is stubbed out within tests
+var logStoreError = func(c context.Context, format string, args ...interface{}) {
+	log.Errorf(c, format, args...)
+}
@@ -0,0 +1,681 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/memcache"
+)
+
+var errNestedTransaction = errors.New("nested transactions are not supported")
+
+// MemoryBackend is an in-memory Backend implementation that allows stores,
+// and the services built on them, to be tested without the App Engine
+// development server.
+type MemoryBackend struct {
+	mu       sync.Mutex
+	txMu     sync.Mutex
+	lastID   int64
+	entities map[string]memoryEntity
+	cache    map[string]memoryItem
+}
+
+type memoryEntity struct {
+	key   *datastore.Key
+	props []datastore.Property
+}
+
+// memoryTx holds the writes made within a transaction until it is committed
+type memoryTx struct {
+	puts    map[string]memoryEntity
+	deletes map[string]bool
+}
+
+var memoryTxKey = contextKey("memory-tx")
+
+type memoryItem struct {
+	value   []byte
+	expires time.Time
+}
+
+// NewMemoryBackend creates an empty in-memory backend
+func NewMemoryBackend() *MemoryBackend {
+	return &MemoryBackend{
+		entities: make(map[string]memoryEntity),
+		cache:    make(map[string]memoryItem),
+	}
+}
+
+// NewMemoryContext returns a context, usable outside of App Engine, in which
+// stores use a new in-memory backend.
+//  func TestSomething(t *testing.T) {
+//  	c := ae.NewMemoryContext()
+//  	key, err := ae.NewStore("users").Create(c, &u, nil)
+//  }
+func NewMemoryContext() context.Context {
+	// keys can't be created without an app id
+	if os.Getenv("GAE_APPLICATION") == "" {
+		os.Setenv("GAE_APPLICATION", "testapp")
+	}
+	return WithBackend(context.Background(), NewMemoryBackend())
+}
+
+// Put saves the entity, allocating an id for incomplete keys. Complete keys
+// advance the last allocated id past their own, as imported entities do.
+func (m *MemoryBackend) Put(c context.Context, key *datastore.Key, src interface{}) (*datastore.Key, error) {
+	if key == nil {
+		return nil, datastore.ErrInvalidKey
+	}
+	props, err := saveEntity(src)
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if key.Incomplete() {
+		nc, err := appengine.Namespace(c, key.Namespace())
+		if err != nil {
+			return nil, err
+		}
+		m.lastID++
+		key = datastore.NewKey(nc, key.Kind(), "", m.lastID, key.Parent())
+	} else if key.IntID() > m.lastID {
+		m.lastID = key.IntID()
+	}
+	e, encodedKey := memoryEntity{key: key, props: props}, key.Encode()
+	if tx, ok := c.Value(memoryTxKey).(*memoryTx); ok {
+		tx.puts[encodedKey] = e
+		delete(tx.deletes, encodedKey)
+		return key, nil
+	}
+	m.entities[encodedKey] = e
+	return key, nil
+}
+
+// Get loads the entity into dst
+func (m *MemoryBackend) Get(c context.Context, key *datastore.Key, dst interface{}) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	encodedKey := key.Encode()
+	if tx, ok := c.Value(memoryTxKey).(*memoryTx); ok {
+		if tx.deletes[encodedKey] {
+			return datastore.ErrNoSuchEntity
+		}
+		if e, ok := tx.puts[encodedKey]; ok {
+			return loadEntity(dst, e.props)
+		}
+	}
+	m.mu.Lock()
+	e, ok := m.entities[encodedKey]
+	m.mu.Unlock()
+	if !ok {
+		return datastore.ErrNoSuchEntity
+	}
+	return loadEntity(dst, e.props)
+}
+
+// Delete removes the entity
+func (m *MemoryBackend) Delete(c context.Context, key *datastore.Key) error {
+	if key == nil || key.Incomplete() {
+		return datastore.ErrInvalidKey
+	}
+	encodedKey := key.Encode()
+	if tx, ok := c.Value(memoryTxKey).(*memoryTx); ok {
+		tx.deletes[encodedKey] = true
+		delete(tx.puts, encodedKey)
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entities, encodedKey)
+	return nil
+}
+
+// RunInTransaction runs f with the writes being applied only if f returns nil.
+// Transactions are run one at a time, so never fail due to contention.
+func (m *MemoryBackend) RunInTransaction(c context.Context, f func(tc context.Context) error, opts *datastore.TransactionOptions) error {
+	if c.Value(memoryTxKey) != nil {
+		return errNestedTransaction
+	}
+	m.txMu.Lock()
+	defer m.txMu.Unlock()
+
+	tx := &memoryTx{
+		puts:    make(map[string]memoryEntity),
+		deletes: make(map[string]bool),
+	}
+	if err := f(context.WithValue(c, memoryTxKey, tx)); err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for k, e := range tx.puts {
+		m.entities[k] = e
+	}
+	for k := range tx.deletes {
+		delete(m.entities, k)
+	}
+	return nil
+}
+
+// PutMulti saves each of the entities
+func (m *MemoryBackend) PutMulti(c context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	sv := reflect.ValueOf(src)
+	if sv.Kind() != reflect.Slice || sv.Len() != len(keys) {
+		return nil, errInvalidMultiArg
+	}
+	ret := make([]*datastore.Key, len(keys))
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		ret[i], errs[i] = m.Put(c, key, elemPointer(sv.Index(i)))
+		hasErr = hasErr || errs[i] != nil
+	}
+	if hasErr {
+		return ret, errs
+	}
+	return ret, nil
+}
+
+// GetMulti loads each of the entities
+func (m *MemoryBackend) GetMulti(c context.Context, keys []*datastore.Key, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Slice || dv.Len() != len(keys) {
+		return errInvalidMultiArg
+	}
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		errs[i] = m.Get(c, key, elemPointer(dv.Index(i)))
+		hasErr = hasErr || errs[i] != nil
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// DeleteMulti removes each of the entities
+func (m *MemoryBackend) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		errs[i] = m.Delete(c, key)
+		hasErr = hasErr || errs[i] != nil
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// GetAll runs the query against the saved entities. dst must be a pointer to a
+// slice of structs, struct pointers or PropertyLoadSavers, and may be nil for
+// keys only queries.
+func (m *MemoryBackend) GetAll(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	keys, _, err := m.GetPage(c, q, dst)
+	return keys, err
+}
+
+// GetPage runs the query in the same way as GetAll, also returning the cursor
+// positioned after the last result. Memory cursors are the result index.
+func (m *MemoryBackend) GetPage(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, string, error) {
+	var dv reflect.Value
+	if !q.keysOnly {
+		var err error
+		if dv, err = sliceValue(dst); err != nil {
+			return nil, "", err
+		}
+	}
+	start, err := decodeMemoryCursor(q.start, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	end, err := decodeMemoryCursor(q.end, -1)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ns := datastore.NewIncompleteKey(c, q.kind, nil).Namespace()
+	if q.ancestor != nil {
+		ns = q.ancestor.Namespace()
+	}
+
+	m.mu.Lock()
+	var matches []memoryEntity
+	for _, e := range m.entities {
+		if e.key.Kind() == q.kind && e.key.Namespace() == ns && m.matches(q, e) {
+			matches = append(matches, e)
+		}
+	}
+	m.mu.Unlock()
+
+	sort.Stable(entitySorter{entities: matches, orders: q.orders})
+
+	if end >= 0 && end < len(matches) {
+		matches = matches[:end]
+	}
+	pos := start + q.offset
+	if pos > len(matches) {
+		pos = len(matches)
+	}
+	matches = matches[pos:]
+	if q.limit >= 0 && q.limit < len(matches) {
+		matches = matches[:q.limit]
+	}
+	pos += len(matches)
+
+	keys := make([]*datastore.Key, len(matches))
+	var loadErr error
+	for i, e := range matches {
+		keys[i] = e.key
+		if q.keysOnly {
+			continue
+		}
+
+		ev := newSliceElem(dv)
+		if err := loadEntity(elemPointer(ev), e.props); err != nil {
+			if _, ok := err.(*datastore.ErrFieldMismatch); !ok {
+				return nil, "", err
+			}
+			loadErr = err
+		}
+		dv.Set(reflect.Append(dv, ev))
+	}
+	return keys, encodeMemoryCursor(pos), loadErr
+}
+
+func encodeMemoryCursor(pos int) string {
+	return base64.URLEncoding.EncodeToString([]byte(strconv.Itoa(pos)))
+}
+
+func decodeMemoryCursor(cursor string, defaultPos int) (int, error) {
+	if cursor == "" {
+		return defaultPos, nil
+	}
+	b, err := base64.URLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	pos, err := strconv.Atoi(string(b))
+	if err != nil || pos < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return pos, nil
+}
+
+// entitySorter sorts the entities by the query orders followed by their keys
+type entitySorter struct {
+	entities []memoryEntity
+	orders   []queryOrder
+}
+
+func (s entitySorter) Len() int      { return len(s.entities) }
+func (s entitySorter) Swap(i, j int) { s.entities[i], s.entities[j] = s.entities[j], s.entities[i] }
+func (s entitySorter) Less(i, j int) bool {
+	for _, o := range s.orders {
+		a, _ := firstValue(s.entities[i], o.field)
+		b, _ := firstValue(s.entities[j], o.field)
+		n, _ := compareValues(a, b)
+		if n == 0 {
+			continue
+		}
+		if o.desc {
+			return n > 0
+		}
+		return n < 0
+	}
+	return compareKeys(s.entities[i].key, s.entities[j].key) < 0
+}
+
+// matches must be called with the lock held
+func (m *MemoryBackend) matches(q *Query, e memoryEntity) bool {
+	if q.ancestor != nil {
+		found := false
+		for k := e.key; k != nil; k = k.Parent() {
+			if k.Equal(q.ancestor) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for _, f := range q.filters {
+		vals := values(e, f.field)
+		if len(vals) == 0 {
+			return false
+		}
+		ok := false
+		for _, v := range vals {
+			n, comparable := compareValues(v, f.value)
+			if comparable && compareOp(n, f.op) {
+				ok = true
+				break
+			}
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	// entities without the property being ordered on are not returned by the datastore
+	for _, o := range q.orders {
+		if len(values(e, o.field)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// cacheKey namespaces the key, in the same way as memcache, to the namespace
+// of the context
+func cacheKey(c context.Context, key string) string {
+	return datastore.NewIncompleteKey(c, "cache", nil).Namespace() + ":" + key
+}
+
+// CacheGet gob decodes the cached item into dst
+func (m *MemoryBackend) CacheGet(c context.Context, key string, dst interface{}) error {
+	key = cacheKey(c, key)
+	m.mu.Lock()
+	item, ok := m.cache[key]
+	if ok && !item.expires.IsZero() && item.expires.Before(time.Now()) {
+		delete(m.cache, key)
+		ok = false
+	}
+	m.mu.Unlock()
+	if !ok {
+		return memcache.ErrCacheMiss
+	}
+	return gob.NewDecoder(bytes.NewReader(item.value)).Decode(dst)
+}
+
+// CacheSet gob encodes src into the cache
+func (m *MemoryBackend) CacheSet(c context.Context, key string, src interface{}, expiration time.Duration) error {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(src); err != nil {
+		return err
+	}
+	key = cacheKey(c, key)
+	item := memoryItem{value: buf.Bytes()}
+	if expiration > 0 {
+		item.expires = time.Now().Add(expiration)
+	}
+	m.mu.Lock()
+	m.cache[key] = item
+	m.mu.Unlock()
+	return nil
+}
+
+// CacheDelete removes the item from the cache
+func (m *MemoryBackend) CacheDelete(c context.Context, key string) error {
+	key = cacheKey(c, key)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.cache[key]; !ok {
+		return memcache.ErrCacheMiss
+	}
+	delete(m.cache, key)
+	return nil
+}
+
+// CacheGetMulti gob decodes each of the cached items into the dst slice elements
+func (m *MemoryBackend) CacheGetMulti(c context.Context, keys []string, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Slice || dv.Len() != len(keys) {
+		return errInvalidMultiArg
+	}
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		errs[i] = m.CacheGet(c, key, elemPointer(dv.Index(i)))
+		hasErr = hasErr || errs[i] != nil
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// CacheSetMulti gob encodes each of the src slice elements into the cache
+func (m *MemoryBackend) CacheSetMulti(c context.Context, keys []string, src interface{}, expiration time.Duration) error {
+	sv := reflect.ValueOf(src)
+	if sv.Kind() != reflect.Slice || sv.Len() != len(keys) {
+		return errInvalidMultiArg
+	}
+	for i, key := range keys {
+		if err := m.CacheSet(c, key, sv.Index(i).Interface(), expiration); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CacheDeleteMulti removes the items from the cache
+func (m *MemoryBackend) CacheDeleteMulti(c context.Context, keys []string) error {
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	for i, key := range keys {
+		errs[i] = m.CacheDelete(c, key)
+		hasErr = hasErr || errs[i] != nil
+	}
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+func saveEntity(src interface{}) ([]datastore.Property, error) {
+	if pls, ok := src.(datastore.PropertyLoadSaver); ok {
+		return pls.Save()
+	}
+	return datastore.SaveStruct(src)
+}
+
+func loadEntity(dst interface{}, props []datastore.Property) error {
+	// the loaders may modify the slice
+	props = append([]datastore.Property(nil), props...)
+	if pls, ok := dst.(datastore.PropertyLoadSaver); ok {
+		return pls.Load(props)
+	}
+	return datastore.LoadStruct(dst, props)
+}
+
+func values(e memoryEntity, field string) []interface{} {
+	if field == "__key__" {
+		return []interface{}{e.key}
+	}
+	var vals []interface{}
+	for _, p := range e.props {
+		if p.Name == field {
+			vals = append(vals, p.Value)
+		}
+	}
+	return vals
+}
+
+func firstValue(e memoryEntity, field string) (interface{}, bool) {
+	vals := values(e, field)
+	if len(vals) == 0 {
+		return nil, false
+	}
+	return vals[0], true
+}
+
+func compareOp(n int, op string) bool {
+	switch op {
+	case "=":
+		return n == 0
+	case "<":
+		return n < 0
+	case "<=":
+		return n <= 0
+	case ">":
+		return n > 0
+	case ">=":
+		return n >= 0
+	}
+	return false
+}
+
+// compareValues returns -1, 0 or 1 and whether the two values could be compared
+func compareValues(a, b interface{}) (int, bool) {
+	a, b = normalizeValue(a), normalizeValue(b)
+	switch av := a.(type) {
+	case nil:
+		if b == nil {
+			return 0, true
+		}
+	case int64:
+		if bv, ok := b.(int64); ok {
+			return compareInts(av, bv), true
+		}
+		if bv, ok := b.(float64); ok {
+			return compareFloats(float64(av), bv), true
+		}
+	case float64:
+		if bv, ok := b.(float64); ok {
+			return compareFloats(av, bv), true
+		}
+		if bv, ok := b.(int64); ok {
+			return compareFloats(av, float64(bv)), true
+		}
+	case string:
+		if bv, ok := b.(string); ok {
+			return strings.Compare(av, bv), true
+		}
+	case bool:
+		if bv, ok := b.(bool); ok {
+			switch {
+			case av == bv:
+				return 0, true
+			case !av:
+				return -1, true
+			default:
+				return 1, true
+			}
+		}
+	case time.Time:
+		if bv, ok := b.(time.Time); ok {
+			switch {
+			case av.Before(bv):
+				return -1, true
+			case av.After(bv):
+				return 1, true
+			default:
+				return 0, true
+			}
+		}
+	case *datastore.Key:
+		if bv, ok := b.(*datastore.Key); ok {
+			return compareKeys(av, bv), true
+		}
+	case []byte:
+		if bv, ok := b.([]byte); ok {
+			return bytes.Compare(av, bv), true
+		}
+	}
+	return 0, false
+}
+
+func normalizeValue(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int8:
+		return int64(x)
+	case int16:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case float32:
+		return float64(x)
+	case datastore.ByteString:
+		return []byte(x)
+	}
+	return v
+}
+
+func compareInts(a, b int64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+func compareFloats(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// compareKeys orders keys by their path, with int ids before string ids
+func compareKeys(a, b *datastore.Key) int {
+	if a == nil || b == nil {
+		switch {
+		case a == b:
+			return 0
+		case a == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+	pa, pb := keyPath(a), keyPath(b)
+	for i := 0; i < len(pa) && i < len(pb); i++ {
+		x, y := pa[i], pb[i]
+		if n := strings.Compare(x.Kind(), y.Kind()); n != 0 {
+			return n
+		}
+		xNamed, yNamed := x.StringID() != "", y.StringID() != ""
+		switch {
+		case xNamed != yNamed:
+			if xNamed {
+				return 1
+			}
+			return -1
+		case xNamed:
+			if n := strings.Compare(x.StringID(), y.StringID()); n != 0 {
+				return n
+			}
+		default:
+			if n := compareInts(x.IntID(), y.IntID()); n != 0 {
+				return n
+			}
+		}
+	}
+	return compareInts(int64(len(pa)), int64(len(pb)))
+}
+
+func keyPath(k *datastore.Key) []*datastore.Key {
+	var path []*datastore.Key
+	for ; k != nil; k = k.Parent() {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	return path
+}
@@ -0,0 +1,105 @@
+package ae
+
+import (
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+type memPerson struct {
+	Model
+	Name string
+	Age  int
+	Tags []string
+}
+
+func TestMemoryStoreCRUD(t *testing.T) {
+	c := NewMemoryContext()
+	s := NewStore("people")
+
+	key, err := s.Create(c, &memPerson{Name: "Jim"}, nil)
+	if err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+	if key.Incomplete() {
+		t.Error("key was not completed")
+		return
+	}
+
+	var p memPerson
+	if _, err = s.Get(c, key, &p); err != nil || p.Name != "Jim" {
+		t.Errorf("failed to get: %v %v", err, p)
+		return
+	}
+
+	// cached copy must be cleared on update
+	p.Name = "Sam"
+	if err = s.Update(c, key, &p); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+	var p2 memPerson
+	s.Get(c, key, &p2)
+	if p2.Name != "Sam" {
+		t.Errorf("stale cached value returned: %v", p2.Name)
+	}
+
+	if err = s.Delete(c, key); err != nil {
+		t.Errorf("failed to delete: %v", err)
+		return
+	}
+	if _, err = s.Get(c, key, &p2); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expected ErrNoSuchEntity: %v", err)
+	}
+}
+
+func TestMemoryQuery(t *testing.T) {
+	c := NewMemoryContext()
+	b := BackendFromContext(c)
+	s := NewStore("people")
+
+	parentKey, _ := s.Create(c, &memPerson{Name: "parent"}, nil)
+	s.Create(c, &memPerson{Name: "Ann", Age: 30, Tags: []string{"a", "b"}}, parentKey)
+	s.Create(c, &memPerson{Name: "Bob", Age: 20, Tags: []string{"b"}}, parentKey)
+	s.Create(c, &memPerson{Name: "Cal", Age: 40}, parentKey)
+	s.Create(c, &memPerson{Name: "Dan", Age: 30}, nil)
+
+	type test struct {
+		name  string
+		q     *Query
+		names []string
+	}
+
+	tests := []test{
+		test{name: "ancestor", q: NewQuery("people").Ancestor(parentKey).Order("Name"), names: []string{"Ann", "Bob", "Cal", "parent"}},
+		test{name: "equality", q: NewQuery("people").Filter("Age =", 30).Order("Name"), names: []string{"Ann", "Dan"}},
+		test{name: "inequality", q: NewQuery("people").Filter("Age >", 20).Order("-Age"), names: []string{"Cal", "Ann", "Dan"}},
+		test{name: "multi-value", q: NewQuery("people").Filter("Tags =", "b").Order("Name"), names: []string{"Ann", "Bob"}},
+		test{name: "order desc", q: NewQuery("people").Order("-Name"), names: []string{"parent", "Dan", "Cal", "Bob", "Ann"}},
+		test{name: "offset limit", q: NewQuery("people").Order("Name").Offset(1).Limit(2), names: []string{"Bob", "Cal"}},
+	}
+
+	for _, test := range tests {
+		var people []*memPerson
+		keys, err := b.GetAll(c, test.q, &people)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(keys) != len(test.names) || len(people) != len(test.names) {
+			t.Errorf("%s: expected %d results, got %d", test.name, len(test.names), len(people))
+			continue
+		}
+		for i, p := range people {
+			if p.Name != test.names[i] {
+				t.Errorf("%s: expected %s at %d, got %s", test.name, test.names[i], i, p.Name)
+			}
+		}
+	}
+
+	keys, err := b.GetAll(c, NewQuery("people").Ancestor(parentKey).KeysOnly(), nil)
+	if err != nil || len(keys) != 4 {
+		t.Errorf("keys only: %v %d", err, len(keys))
+	}
+}
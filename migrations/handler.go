@@ -0,0 +1,77 @@
+package migrations
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/log"
+	"google.golang.org/appengine/taskqueue"
+	"google.golang.org/appengine/user"
+)
+
+// Handle runs a batch of the pending migrations for the `kind` form value,
+// and responds with the JSON result. Unless it is a dry run, a task is added
+// to run the next batch until all of the kind's migrations are done. Only
+// admins and the task queue are allowed access.
+//  router.Post("/admin/migrations", q.HandleFunc(migrations.Handle))
+//
+// Form values:
+//  kind       the kind to migrate
+//  batchSize  optional number of entities per batch
+//  dryRun     `true` to only report what would change
+//  cursor     cursor of the next dry run batch
+//  queue      optional task queue name
+func Handle(c context.Context, w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get("X-AppEngine-QueueName") == "" && !user.IsAdmin(c) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+
+	kind := r.FormValue("kind")
+	if kind == "" {
+		http.Error(w, "missing kind", http.StatusBadRequest)
+		return
+	}
+	batchSize, _ := strconv.Atoi(r.FormValue("batchSize"))
+	opts := Options{
+		BatchSize: batchSize,
+		DryRun:    r.FormValue("dryRun") == "true",
+		Cursor:    r.FormValue("cursor"),
+	}
+
+	result, err := RunBatch(c, kind, opts)
+	if err == ErrNoPending {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": kind, "done": true})
+		return
+	}
+	if err == ErrBatchRun {
+		// the run that claimed the batch adds the task of the next one
+		log.Infof(c, "migrating %s: %v", kind, err)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"kind": kind, "batchRun": true})
+		return
+	}
+	if err != nil {
+		log.Errorf(c, "migrating %s: %v", kind, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// the next batch, or migration, is run within a new request
+	if !opts.DryRun {
+		params := url.Values{"kind": {kind}, "batchSize": {r.FormValue("batchSize")}, "queue": {r.FormValue("queue")}}
+		t := taskqueue.NewPOSTTask(r.URL.Path, params)
+		if _, err = taskqueue.Add(c, t, r.FormValue("queue")); err != nil {
+			log.Errorf(c, "adding migration task for %s: %v", kind, err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
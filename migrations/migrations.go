@@ -0,0 +1,382 @@
+package migrations
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"github.com/chrisolsen/ae"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	tableName        = "migrations"
+	defaultBatchSize = 100
+)
+
+// Errors
+var (
+	ErrNoPending = errors.New("no pending migrations")
+	ErrBatchRun  = errors.New("batch already run")
+)
+
+var store = ae.NewStore(tableName)
+
+type contextKey string
+
+var dryRunKey = contextKey("dryRun")
+
+// migrations are run within cross group transactions, for the Migrate funcs to
+// be able to save other entities, such as the markers of unique values
+var txOptions = &datastore.TransactionOptions{XG: true}
+
+// registered migrations by kind, sorted by version
+var registry = make(map[string][]Migration)
+
+// Migration is a numbered change to the entities of a kind
+type Migration struct {
+	Kind    string
+	Version int
+	Name    string
+
+	// New returns a pointer to a new model to load each entity into. If not set
+	// the entities are loaded into a datastore.PropertyList, which allows for
+	// fields to be renamed or removed.
+	New func() interface{}
+
+	// Migrate modifies the loaded entity and returns whether it was changed,
+	// with only the changed entities being saved
+	Migrate func(c context.Context, key *datastore.Key, entity interface{}) (bool, error)
+}
+
+// Register adds the migration to the migrations of its kind, panicking if the
+// version isn't positive or is already registered
+//  migrations.Register(migrations.Migration{
+//  	Kind:    "posts",
+//  	Version: 1,
+//  	Name:    "rename Body to Content",
+//  	Migrate: func(c context.Context, key *datastore.Key, entity interface{}) (bool, error) {
+//  		return migrations.RenameProperty(entity.(*datastore.PropertyList), "Body", "Content"), nil
+//  	},
+//  })
+func Register(m Migration) {
+	if m.Version < 1 {
+		panic(fmt.Sprintf("migrations: invalid version %d for %s", m.Version, m.Kind))
+	}
+	if m.Migrate == nil {
+		panic(fmt.Sprintf("migrations: missing Migrate func for %s %d", m.Kind, m.Version))
+	}
+	for _, r := range registry[m.Kind] {
+		if r.Version == m.Version {
+			panic(fmt.Sprintf("migrations: version %d already registered for %s", m.Version, m.Kind))
+		}
+	}
+	registry[m.Kind] = append(registry[m.Kind], m)
+	sort.Sort(byVersion(registry[m.Kind]))
+}
+
+type byVersion []Migration
+
+func (v byVersion) Len() int           { return len(v) }
+func (v byVersion) Swap(i, j int)      { v[i], v[j] = v[j], v[i] }
+func (v byVersion) Less(i, j int) bool { return v[i].Version < v[j].Version }
+
+// RenameProperty renames the property within the property list, returning
+// whether it existed
+func RenameProperty(props *datastore.PropertyList, from, to string) bool {
+	var changed bool
+	for i, p := range *props {
+		if p.Name == from {
+			(*props)[i].Name = to
+			changed = true
+		}
+	}
+	return changed
+}
+
+// AddProperty appends the property to the property list if it isn't already
+// set, returning whether it was added
+func AddProperty(props *datastore.PropertyList, p datastore.Property) bool {
+	for _, existing := range *props {
+		if existing.Name == p.Name {
+			return false
+		}
+	}
+	*props = append(*props, p)
+	return true
+}
+
+// BackfillSoftDelete returns the migration that sets the Deleted property of
+// the kind's entities saved before its model embedded ae.SoftDelete, which are
+// otherwise excluded from the Store's queries
+//  migrations.Register(migrations.BackfillSoftDelete("posts", 2))
+func BackfillSoftDelete(kind string, version int) Migration {
+	return Migration{
+		Kind:    kind,
+		Version: version,
+		Name:    "backfill Deleted",
+		Migrate: func(c context.Context, key *datastore.Key, entity interface{}) (bool, error) {
+			return AddProperty(entity.(*datastore.PropertyList), datastore.Property{Name: "Deleted", Value: false}), nil
+		},
+	}
+}
+
+// BackfillUnique returns the migration that reserves the unique values of the
+// kind's entities saved before their model implemented ae.Uniquer, which are
+// otherwise never reserved. New returns the model the entities are loaded
+// into. The entities themselves aren't changed, and the migration fails on
+// the first duplicate, which must be resolved before it is rerun.
+//  migrations.Register(migrations.BackfillUnique("users", 3, func() interface{} { return &User{} }))
+func BackfillUnique(kind string, version int, newModel func() interface{}) Migration {
+	s := ae.NewStore(kind)
+	return Migration{
+		Kind:    kind,
+		Version: version,
+		Name:    "backfill unique values",
+		New:     newModel,
+		Migrate: func(c context.Context, key *datastore.Key, entity interface{}) (bool, error) {
+			if DryRun(c) {
+				return false, nil
+			}
+			return false, s.ReserveUnique(c, key, entity)
+		},
+	}
+}
+
+// DryRun indicates if the migration is being run as a dry run, for Migrate
+// funcs that save other entities to skip saving them
+func DryRun(c context.Context) bool {
+	return c.Value(dryRunKey) != nil
+}
+
+// Status is the progress of a migration, which is saved after each batch
+type Status struct {
+	Kind        string    `json:"kind"`
+	Version     int       `json:"version"`
+	Name        string    `json:"name"`
+	Cursor      string    `json:"-" datastore:",noindex"`
+	Processed   int       `json:"processed" datastore:",noindex"`
+	Changed     int       `json:"changed" datastore:",noindex"`
+	Done        bool      `json:"done"`
+	StartedAt   time.Time `json:"startedAt" datastore:",noindex"`
+	CompletedAt time.Time `json:"completedAt" datastore:",noindex"`
+}
+
+func statusKey(c context.Context, kind string, version int) *datastore.Key {
+	return datastore.NewKey(c, tableName, fmt.Sprintf("%s:%d", kind, version), 0, nil)
+}
+
+// GetStatus returns the status of each of the kind's registered migrations
+func GetStatus(c context.Context, kind string) ([]*Status, error) {
+	var statuses []*Status
+	for _, m := range registry[kind] {
+		status, err := getStatus(c, m)
+		if err != nil {
+			return nil, err
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func getStatus(c context.Context, m Migration) (*Status, error) {
+	var status Status
+	_, err := store.Get(c, statusKey(c, m.Kind, m.Version), &status)
+	if err == datastore.ErrNoSuchEntity {
+		return &Status{Kind: m.Kind, Version: m.Version, Name: m.Name}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// pending returns the first of the kind's migrations that is not done
+func pending(c context.Context, kind string) (Migration, *Status, error) {
+	for _, m := range registry[kind] {
+		status, err := getStatus(c, m)
+		if err != nil {
+			return Migration{}, nil, err
+		}
+		if !status.Done {
+			return m, status, nil
+		}
+	}
+	return Migration{}, nil, ErrNoPending
+}
+
+// Options for running a batch
+type Options struct {
+	// Number of entities migrated per batch, which defaults to 100
+	BatchSize int
+
+	// DryRun results in nothing being saved, with the result containing the
+	// keys of the entities that would be changed
+	DryRun bool
+
+	// Cursor to continue a dry run from, which is ignored otherwise as the
+	// cursor of actual runs is saved with the status
+	Cursor string
+}
+
+// Result of running a batch
+type Result struct {
+	Status
+
+	// Cursor of the next batch
+	Cursor string `json:"cursor"`
+	DryRun bool   `json:"dryRun"`
+
+	// Keys of the entities changed within the batch
+	Keys []*datastore.Key `json:"keys"`
+}
+
+// RunBatch runs the next batch of the kind's first pending migration, saving
+// the progress unless it is a dry run. ErrNoPending is returned once all of
+// the kind's migrations are done. The progress is saved within a transaction
+// that claims the batch, by checking that it's still the next one, with
+// ErrBatchRun being returned to concurrent runs of the same batch, such as
+// task retries, for it to only be counted once. Each entity is migrated and saved within its
+// own transaction, with the version of versioned entities being incremented,
+// so that concurrent writes aren't overwritten. Writes to unversioned entities
+// made outside of transactions may still be lost, so those kinds should only
+// be migrated while they aren't being written.
+func RunBatch(c context.Context, kind string, opts Options) (*Result, error) {
+	m, status, err := pending(c, kind)
+	if err != nil {
+		return nil, err
+	}
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = defaultBatchSize
+	}
+	cursor := status.Cursor
+	if opts.DryRun {
+		cursor = opts.Cursor
+	}
+
+	var entityType reflect.Type
+	if m.New != nil {
+		entityType = reflect.TypeOf(m.New())
+	} else {
+		entityType = reflect.TypeOf(&datastore.PropertyList{})
+	}
+	entities := reflect.New(reflect.SliceOf(entityType))
+
+	// soft deleted entities need to be migrated too
+	s := ae.NewStore(kind)
+	q := s.Query().WithDeleted().Limit(opts.BatchSize).Start(cursor)
+	page, err := s.GetPage(c, q, entities.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	var changedKeys []*datastore.Key
+	for i, key := range page.Keys {
+		var ok bool
+		if opts.DryRun {
+			ok, err = m.Migrate(context.WithValue(c, dryRunKey, true), key, entities.Elem().Index(i).Interface())
+		} else {
+			ok, err = migrate(c, m, key, entityType)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("migrating %v: %v", key, err)
+		}
+		if ok {
+			changedKeys = append(changedKeys, key)
+		}
+	}
+
+	advance := func(status *Status) {
+		status.Processed += len(page.Keys)
+		status.Changed += len(changedKeys)
+		status.Cursor = page.Cursor
+		status.Done = page.Cursor == ""
+		if status.StartedAt.IsZero() {
+			status.StartedAt = time.Now()
+		}
+		if status.Done {
+			status.CompletedAt = time.Now()
+		}
+	}
+	result := &Result{Cursor: page.Cursor, DryRun: opts.DryRun, Keys: changedKeys}
+	if opts.DryRun {
+		advance(status)
+		result.Status = *status
+		return result, nil
+	}
+
+	key := statusKey(c, m.Kind, m.Version)
+	err = ae.RunInTransaction(c, func(tc context.Context) error {
+		// reloaded rather than cached, for the batch to be claimed by a
+		// single run
+		saved := Status{Kind: m.Kind, Version: m.Version, Name: m.Name}
+		err := ae.BackendFromContext(tc).Get(tc, key, &saved)
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if saved.Done || saved.Cursor != cursor {
+			return ErrBatchRun
+		}
+		advance(&saved)
+		result.Status = saved
+		return store.Update(tc, key, &saved)
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// migrate reloads the entity and runs the migration on it within a
+// transaction, saving it if it was changed
+func migrate(c context.Context, m Migration, key *datastore.Key, entityType reflect.Type) (bool, error) {
+	b := ae.BackendFromContext(c)
+	var changed bool
+	err := ae.RunInTransaction(c, func(tc context.Context) error {
+		changed = false
+		entity := reflect.New(entityType.Elem()).Interface()
+		err := b.Get(tc, key, entity)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if _, ok := err.(*datastore.ErrFieldMismatch); err != nil && !ok {
+			return err
+		}
+		if changed, err = m.Migrate(tc, key, entity); err != nil || !changed {
+			return err
+		}
+		incrementVersion(entity)
+		_, err = b.Put(tc, key, entity)
+		return err
+	}, txOptions)
+	if err != nil {
+		return false, err
+	}
+	if changed {
+		b.CacheDelete(c, key.Encode())
+	}
+	return changed, nil
+}
+
+// incrementVersion increments the version of versioned entities, for updates
+// of the entities read before they were migrated to conflict
+func incrementVersion(entity interface{}) {
+	switch e := entity.(type) {
+	case ae.Versioner:
+		e.SetModelVersion(e.ModelVersion() + 1)
+	case *datastore.PropertyList:
+		for i, p := range *e {
+			if v, ok := p.Value.(int64); ok && p.Name == "Version" {
+				(*e)[i].Value = v + 1
+			}
+		}
+	}
+}
+
+// Reset clears the saved progress of the migration, allowing it to be rerun
+func Reset(c context.Context, kind string, version int) error {
+	return store.Delete(c, statusKey(c, kind, version))
+}
@@ -0,0 +1,199 @@
+package migrations
+
+import (
+	"testing"
+
+	"github.com/chrisolsen/ae"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type post struct {
+	ae.Model
+	Content string
+}
+
+func TestRunBatch(t *testing.T) {
+	c := ae.NewMemoryContext()
+	b := ae.BackendFromContext(c)
+
+	for _, body := range []string{"a", "b", "c"} {
+		props := datastore.PropertyList{
+			datastore.Property{Name: "Body", Value: body},
+			datastore.Property{Name: "Version", Value: int64(1), NoIndex: true},
+		}
+		b.Put(c, datastore.NewIncompleteKey(c, "posts", nil), &props)
+	}
+
+	Register(Migration{
+		Kind:    "posts",
+		Version: 1,
+		Name:    "rename Body to Content",
+		Migrate: func(c context.Context, key *datastore.Key, entity interface{}) (bool, error) {
+			return RenameProperty(entity.(*datastore.PropertyList), "Body", "Content"), nil
+		},
+	})
+
+	result, err := RunBatch(c, "posts", Options{BatchSize: 2, DryRun: true})
+	if err != nil {
+		t.Errorf("failed to dry run: %v", err)
+		return
+	}
+	if len(result.Keys) != 2 || result.Cursor == "" {
+		t.Errorf("invalid dry run result: %+v", result)
+		return
+	}
+	if statuses, _ := GetStatus(c, "posts"); statuses[0].Processed != 0 {
+		t.Errorf("dry run progress saved: %+v", statuses[0])
+	}
+
+	for batches := 0; ; batches++ {
+		_, err = RunBatch(c, "posts", Options{BatchSize: 2})
+		if err == ErrNoPending {
+			break
+		}
+		if err != nil || batches > 2 {
+			t.Errorf("failed to run batch %d: %v", batches, err)
+			return
+		}
+	}
+
+	var posts []*post
+	ae.NewStore("posts").GetAll(c, ae.NewQuery("posts").Order("Content"), &posts)
+	if len(posts) != 3 || posts[0].Content != "a" || posts[2].Content != "c" || posts[0].Version != 2 {
+		t.Errorf("posts not migrated: %+v", posts)
+	}
+
+	statuses, err := GetStatus(c, "posts")
+	if err != nil || !statuses[0].Done || statuses[0].Processed != 3 || statuses[0].Changed != 3 {
+		t.Errorf("invalid status: %v %+v", err, statuses[0])
+	}
+}
+
+func TestRunBatchClaimed(t *testing.T) {
+	c := ae.NewMemoryContext()
+	b := ae.BackendFromContext(c)
+
+	for _, body := range []string{"a", "b"} {
+		props := datastore.PropertyList{datastore.Property{Name: "Body", Value: body}}
+		b.Put(c, datastore.NewIncompleteKey(c, "comments", nil), &props)
+	}
+
+	// another run of the same batch saves its progress while this one runs
+	var concurrent bool
+	Register(Migration{
+		Kind:    "comments",
+		Version: 1,
+		Name:    "rename Body to Content",
+		Migrate: func(tc context.Context, key *datastore.Key, entity interface{}) (bool, error) {
+			if !concurrent {
+				concurrent = true
+				status := Status{Kind: "comments", Version: 1, Processed: 2, Changed: 2, Done: true}
+				if _, err := b.Put(c, statusKey(c, "comments", 1), &status); err != nil {
+					return false, err
+				}
+			}
+			return RenameProperty(entity.(*datastore.PropertyList), "Body", "Content"), nil
+		},
+	})
+
+	if _, err := RunBatch(c, "comments", Options{}); err != ErrBatchRun {
+		t.Errorf("expected ErrBatchRun: %v", err)
+	}
+	statuses, err := GetStatus(c, "comments")
+	if err != nil || statuses[0].Processed != 2 || statuses[0].Changed != 2 {
+		t.Errorf("batch counted twice: %v %+v", err, statuses[0])
+	}
+}
+
+type note struct {
+	ae.Model
+	ae.SoftDelete
+	Body string
+}
+
+func TestBackfillSoftDelete(t *testing.T) {
+	c := ae.NewMemoryContext()
+	b := ae.BackendFromContext(c)
+	s := ae.Store{TableName: "notes", Model: &note{}}
+
+	props := datastore.PropertyList{datastore.Property{Name: "Body", Value: "legacy"}}
+	b.Put(c, datastore.NewIncompleteKey(c, "notes", nil), &props)
+	s.Create(c, &note{Body: "new"}, nil)
+
+	var notes []*note
+	s.GetAll(c, s.Query(), &notes)
+	if len(notes) != 1 {
+		t.Errorf("%d notes found before the backfill, expected 1", len(notes))
+	}
+
+	Register(BackfillSoftDelete("notes", 1))
+	for {
+		if _, err := RunBatch(c, "notes", Options{}); err == ErrNoPending {
+			break
+		} else if err != nil {
+			t.Errorf("failed to run batch: %v", err)
+			return
+		}
+	}
+
+	notes = nil
+	s.GetAll(c, s.Query(), &notes)
+	if len(notes) != 2 {
+		t.Errorf("%d notes found after the backfill, expected 2", len(notes))
+	}
+}
+
+type member struct {
+	ae.Model
+	Email string
+}
+
+func (m *member) UniqueValues() map[string]string {
+	return map[string]string{"Email": m.Email}
+}
+
+func TestBackfillUnique(t *testing.T) {
+	c := ae.NewMemoryContext()
+	b := ae.BackendFromContext(c)
+	s := ae.Store{TableName: "members", Model: &member{}}
+
+	// saved before the model implemented ae.Uniquer
+	b.Put(c, datastore.NewIncompleteKey(c, "members", nil), &member{Email: "jim@example.com"})
+
+	Register(BackfillUnique("members", 1, func() interface{} { return &member{} }))
+	if _, err := RunBatch(c, "members", Options{DryRun: true}); err != nil {
+		t.Errorf("failed to dry run: %v", err)
+		return
+	}
+	if _, err := s.Create(c, &member{Email: "bob@example.com"}, nil); err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+	for {
+		if _, err := RunBatch(c, "members", Options{}); err == ErrNoPending {
+			break
+		} else if err != nil {
+			t.Errorf("failed to run batch: %v", err)
+			return
+		}
+	}
+
+	type test struct {
+		email     string
+		duplicate bool
+	}
+
+	tests := []test{
+		test{email: "jim@example.com", duplicate: true},
+		test{email: "bob@example.com", duplicate: true},
+		test{email: "sam@example.com"},
+	}
+
+	for _, test := range tests {
+		_, err := s.Create(c, &member{Email: test.email}, nil)
+		if _, duplicate := err.(ae.ErrDuplicateValue); duplicate != test.duplicate {
+			t.Errorf("%s: created with %v, expected a duplicate %v", test.email, err, test.duplicate)
+		}
+	}
+}
@@ -1,11 +1,19 @@
 package ae
 
 import (
+	"fmt"
+	"reflect"
+	"time"
+
 	"google.golang.org/appengine/datastore"
 )
 
 type ErrModelValidation struct {
 	Message string
+
+	// Fields contains the messages of the invalid fields, by field name, when
+	// the model was validated by its `validate` tags
+	Fields map[string]string
 }
 
 func NewValidationError(msg string) ErrModelValidation {
@@ -16,7 +24,104 @@ func (mv ErrModelValidation) Error() string {
 	return mv.Message
 }
 
-// Model has the common key property
+// ErrConflict is returned when a model is updated with a version that no
+// longer matches the saved version, due to it being updated by someone else
+type ErrConflict struct {
+	Key            *datastore.Key
+	Version        int64
+	CurrentVersion int64
+}
+
+func (e ErrConflict) Error() string {
+	return fmt.Sprintf("version %d is out of date, the current version is %d", e.Version, e.CurrentVersion)
+}
+
+// Model has the common key property.
+//
+// Breaking change: the JSON "key" was previously the Key's raw encoding, which
+// is now the public ID. Request bodies that still contain the raw encoding
+// have it decoded into the ID rather than the Key, which DecodeID converts
+// back to the key while the PublicIDs codec accepts encoded keys.
+//  key, err := ae.DecodeID(c, post.ID)
 type Model struct {
-	Key *datastore.Key `json:"key" datastore:"-"`
+	Key *datastore.Key `json:"-" datastore:"-"`
+
+	// ID is the public ID of the Key, set by the Store along with the key,
+	// which is exposed in place of the key's raw encoding
+	ID string `json:"key" datastore:"-"`
+
+	// Version is incremented on each update by the Store. Updates with a
+	// non-zero version that doesn't match the saved version are rejected.
+	Version int64 `json:"version,omitempty" datastore:",noindex"`
+}
+
+// Versioner is implemented by models that are versioned for optimistic
+// concurrency, which includes all models embedding Model
+type Versioner interface {
+	ModelVersion() int64
+	SetModelVersion(version int64)
+}
+
+// ModelVersion returns the model's version
+func (m *Model) ModelVersion() int64 {
+	return m.Version
+}
+
+// SetModelVersion sets the model's version
+func (m *Model) SetModelVersion(version int64) {
+	m.Version = version
+}
+
+// Timestamps can be embedded within models to have their created and updated
+// times maintained by the Store
+type Timestamps struct {
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+func (t *Timestamps) timestamps() *Timestamps {
+	return t
+}
+
+type timestamper interface {
+	timestamps() *Timestamps
+}
+
+// SoftDelete can be embedded within models to have the Store mark them as
+// deleted, rather than deleting them, when the Store's Model is set. Deleted
+// models are hidden from Get and queries, and can be restored or purged.
+// Queries filter on the Deleted property, so the entities saved before the
+// model embedded SoftDelete must be backfilled with it to be found.
+//  migrations.Register(migrations.BackfillSoftDelete("posts", 2))
+type SoftDelete struct {
+	Deleted   bool      `json:"-"`
+	DeletedAt time.Time `json:"deletedAt,omitempty" datastore:",noindex"`
+}
+
+// IsDeleted indicates if the model has been soft deleted
+func (d *SoftDelete) IsDeleted() bool {
+	return d.Deleted
+}
+
+func (d *SoftDelete) softDelete() *SoftDelete {
+	return d
 }
+
+type softDeleter interface {
+	softDelete() *SoftDelete
+}
+
+var softDeleterType = reflect.TypeOf((*softDeleter)(nil)).Elem()
+
+func isSoftDeleter(model interface{}) bool {
+	_, ok := model.(softDeleter)
+	return ok
+}
+
+func isDeleted(model interface{}) bool {
+	d, ok := model.(softDeleter)
+	return ok && d.softDelete().Deleted
+}
+
+// allows the times to be stubbed out within tests
+var timeNow = time.Now
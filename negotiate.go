@@ -0,0 +1,321 @@
+package ae
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// Encoder writes the data in the encoder's format
+type Encoder func(w io.Writer, data interface{}) error
+
+type encoder struct {
+	format    string
+	mediaType string
+	fn        Encoder
+}
+
+var (
+	encodersMu sync.RWMutex
+	encoders   []encoder
+)
+
+func init() {
+	RegisterEncoder("json", "application/json", func(w io.Writer, data interface{}) error {
+		return json.NewEncoder(w).Encode(data)
+	})
+	RegisterEncoder("xml", "application/xml", func(w io.Writer, data interface{}) error {
+		io.WriteString(w, xml.Header)
+		return xml.NewEncoder(w).Encode(data)
+	})
+	RegisterEncoder("csv", "text/csv", encodeCSV)
+}
+
+// RegisterEncoder adds an encoder that Respond can negotiate, replacing any
+// existing encoder of the format. The format is the name used by the
+// `?format=` override.
+//  ae.RegisterEncoder("yaml", "application/x-yaml", func(w io.Writer, data interface{}) error {
+//  	b, err := yaml.Marshal(data)
+//  	w.Write(b)
+//  	return err
+//  })
+func RegisterEncoder(format, mediaType string, fn Encoder) {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	e := encoder{format: format, mediaType: mediaType, fn: fn}
+	for i, existing := range encoders {
+		if existing.format == format {
+			encoders[i] = e
+			return
+		}
+	}
+	encoders = append(encoders, e)
+}
+
+// RespondOptions contains the optional settings of Respond
+type RespondOptions struct {
+	// http status to return in the response, which defaults to 200
+	Status int
+
+	// Template is the view rendered within the handler's layout for HTML
+	// requests, which aren't accepted if it is empty
+	Template string
+
+	// Formats limits the response to the named formats, in order of
+	// preference, which defaults to html, if there is a template, followed by
+	// the registered encoders
+	Formats []string
+}
+
+// Respond writes the data in the format that best matches the request's Accept
+// header, or the `format` query param, responding with a 406 status if none of
+// the formats are acceptable.
+//  h.Respond(posts, ae.RespondOptions{Template: "posts/index"})
+func (h *Handler) Respond(data interface{}, opts RespondOptions) {
+	h.Res.Header().Add("Vary", "Accept")
+	candidates := h.responseFormats(opts)
+	e, ok := negotiate(h.Req, candidates)
+	if !ok {
+		var types []string
+		for _, c := range candidates {
+			types = append(types, c.mediaType)
+		}
+		http.Error(h.Res, "Acceptable types: "+strings.Join(types, ", "), http.StatusNotAcceptable)
+		return
+	}
+
+	status := opts.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if e.format == "html" {
+		h.RenderTemplate(opts.Template, data, RenderOptions{
+			Status:  status,
+			Name:    h.config.ParentLayoutName,
+			FuncMap: h.templateHelpers,
+			Parents: []string{filepath.Join(h.config.LayoutPath, h.config.LayoutFileName)},
+		})
+		return
+	}
+
+	// encoded before the status is sent, for errors to be reported
+	var buf bytes.Buffer
+	if err := e.fn(&buf, data); err != nil {
+		h.Abort(http.StatusInternalServerError, fmt.Errorf("encoding %s: %v", e.format, err))
+		return
+	}
+	h.Res.Header().Set("Content-Type", e.mediaType+"; charset=utf-8")
+	h.Res.WriteHeader(status)
+	buf.WriteTo(h.Res)
+}
+
+// responseFormats returns the encoders of the allowed formats
+func (h *Handler) responseFormats(opts RespondOptions) []encoder {
+	encodersMu.RLock()
+	defer encodersMu.RUnlock()
+	var all []encoder
+	if opts.Template != "" {
+		all = append(all, encoder{format: "html", mediaType: "text/html"})
+	}
+	all = append(all, encoders...)
+	if opts.Formats == nil {
+		return all
+	}
+	var allowed []encoder
+	for _, format := range opts.Formats {
+		for _, e := range all {
+			if e.format == format {
+				allowed = append(allowed, e)
+			}
+		}
+	}
+	return allowed
+}
+
+// negotiate picks the encoder of the format query param, or the one with the
+// highest quality within the Accept header, preferring the earlier encoders
+func negotiate(r *http.Request, candidates []encoder) (encoder, bool) {
+	if format := r.URL.Query().Get("format"); format != "" {
+		for _, e := range candidates {
+			if e.format == format {
+				return e, true
+			}
+		}
+		return encoder{}, false
+	}
+	if len(candidates) == 0 {
+		return encoder{}, false
+	}
+
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return candidates[0], true
+	}
+	ranges := parseAccept(accept)
+	var best encoder
+	var bestQ float64
+	for _, e := range candidates {
+		if q := quality(ranges, e.mediaType); q > bestQ {
+			best, bestQ = e, q
+		}
+	}
+	return best, bestQ > 0
+}
+
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses the media ranges of the Accept header
+func parseAccept(accept string) []mediaRange {
+	var ranges []mediaRange
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		r := mediaRange{mediaType: strings.ToLower(strings.TrimSpace(params[0])), q: 1}
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if q, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					r.q = q
+				}
+			}
+		}
+		if r.mediaType != "" {
+			ranges = append(ranges, r)
+		}
+	}
+	return ranges
+}
+
+// quality returns the q-value of the most specific range matching the type
+func quality(ranges []mediaRange, mediaType string) float64 {
+	var q float64
+	specificity := -1
+	for _, r := range ranges {
+		var s int
+		switch {
+		case r.mediaType == mediaType:
+			s = 2
+		case strings.HasSuffix(r.mediaType, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(r.mediaType, "*")):
+			s = 1
+		case r.mediaType == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s > specificity {
+			q, specificity = r.q, s
+		}
+	}
+	return q
+}
+
+// accepts indicates if the request prefers the media type to HTML
+func accepts(r *http.Request, mediaType string) bool {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	q := quality(ranges, mediaType)
+	return q > 0 && q >= quality(ranges, "text/html")
+}
+
+// encodeCSV writes a slice of structs as rows, with a header row of the field
+// names, or a slice of string slices as is
+func encodeCSV(w io.Writer, data interface{}) error {
+	cw := csv.NewWriter(w)
+	if rows, ok := data.([][]string); ok {
+		cw.WriteAll(rows)
+		return cw.Error()
+	}
+
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Slice {
+		return fmt.Errorf("%T can't be encoded as CSV", data)
+	}
+	t := v.Type().Elem()
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fmt.Errorf("%T can't be encoded as CSV", data)
+	}
+	fields := csvFields(t)
+	header := make([]string, len(fields))
+	for i, f := range fields {
+		header[i] = f.name
+	}
+	cw.Write(header)
+	for i := 0; i < v.Len(); i++ {
+		elem := reflect.Indirect(v.Index(i))
+		row := make([]string, len(fields))
+		for j, f := range fields {
+			if elem.IsValid() {
+				row[j] = csvValue(elem.FieldByIndex(f.index))
+			}
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+type csvField struct {
+	name  string
+	index []int
+}
+
+// csvFields returns the exported fields by their JSON names, including those
+// of embedded structs
+func csvFields(t reflect.Type) []csvField {
+	var fields []csvField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && name == "" {
+			for _, ef := range csvFields(f.Type) {
+				ef.index = append([]int{i}, ef.index...)
+				fields = append(fields, ef)
+			}
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields = append(fields, csvField{name: name, index: []int{i}})
+	}
+	return fields
+}
+
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr && v.IsNil() {
+		return ""
+	}
+	switch value := v.Interface().(type) {
+	case *datastore.Key:
+		return EncodeID(value)
+	case time.Time:
+		return value.Format(time.RFC3339)
+	case fmt.Stringer:
+		return value.String()
+	case []byte:
+		return string(value)
+	}
+	return fmt.Sprint(reflect.Indirect(v).Interface())
+}
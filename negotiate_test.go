@@ -0,0 +1,79 @@
+package ae
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type negotiatedPost struct {
+	Model
+	Title string `json:"title" xml:"title"`
+	Views int    `json:"views" xml:"views"`
+}
+
+func TestRespond(t *testing.T) {
+	RegisterEncoder("text", "text/plain", func(w io.Writer, data interface{}) error {
+		_, err := io.WriteString(w, "posts")
+		return err
+	})
+	defer func() {
+		encodersMu.Lock()
+		encoders = encoders[:len(encoders)-1]
+		encodersMu.Unlock()
+	}()
+
+	posts := []negotiatedPost{
+		negotiatedPost{Title: "foo", Views: 1},
+		negotiatedPost{Title: "bar, baz", Views: 2},
+	}
+
+	type test struct {
+		url         string
+		accept      string
+		formats     []string
+		status      int
+		contentType string
+		body        string
+	}
+
+	tests := []test{
+		test{url: "/", status: 200, contentType: "application/json", body: `"title":"foo"`},
+		test{url: "/", accept: "*/*", status: 200, contentType: "application/json"},
+		test{url: "/", accept: "application/xml", status: 200, contentType: "application/xml", body: "<title>foo</title>"},
+		test{url: "/", accept: "text/csv", status: 200, contentType: "text/csv", body: "key,version,title,views\n,0,foo,1\n,0,\"bar, baz\",2\n"},
+		test{url: "/", accept: "application/json;q=0.5, application/xml;q=0.9", status: 200, contentType: "application/xml"},
+		test{url: "/", accept: "text/*;q=0.8, text/csv;q=0", status: 200, contentType: "text/plain", body: "posts"},
+		test{url: "/", accept: "image/png", status: 406},
+		test{url: "/?format=csv", accept: "application/json", status: 200, contentType: "text/csv"},
+		test{url: "/?format=pdf", status: 406},
+		test{url: "/", accept: "application/xml, application/json", formats: []string{"json"}, status: 200, contentType: "application/json"},
+		test{url: "/?format=xml", formats: []string{"json"}, status: 406},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.url, nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		h := DefaultHandler()
+		h.Bind(NewMemoryContext(), w, r)
+		h.Respond(posts, RespondOptions{Formats: test.formats})
+
+		if w.Code != test.status || w.Header().Get("Vary") != "Accept" {
+			t.Errorf("%s %s: status %d, expected %d", test.url, test.accept, w.Code, test.status)
+			continue
+		}
+		if test.status != 200 {
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, test.contentType) {
+			t.Errorf("%s %s: content type %s, expected %s", test.url, test.accept, ct, test.contentType)
+			continue
+		}
+		if !strings.Contains(w.Body.String(), test.body) {
+			t.Errorf("%s %s: body %q doesn't contain %q", test.url, test.accept, w.Body, test.body)
+		}
+	}
+}
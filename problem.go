@@ -0,0 +1,186 @@
+package ae
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+	"google.golang.org/appengine/log"
+)
+
+// HTTPError is an error responded with its status, where the Message and
+// Fields are shown to the client and the wrapped Err is only logged.
+//  if post == nil {
+//  	h.Error(ae.NotFound("post not found"))
+//  	return
+//  }
+type HTTPError struct {
+	Status  int
+	Message string
+	Fields  map[string]string
+	Err     error
+}
+
+func (e HTTPError) Error() string {
+	msg := e.Message
+	if msg == "" {
+		msg = http.StatusText(e.Status)
+	}
+	if e.Err != nil {
+		msg += ": " + e.Err.Error()
+	}
+	return msg
+}
+
+// BadRequest is the error of requests that can't be processed
+func BadRequest(msg string) HTTPError {
+	return HTTPError{Status: http.StatusBadRequest, Message: msg}
+}
+
+// Unauthorized is the error of requests that require authentication
+func Unauthorized(msg string) HTTPError {
+	return HTTPError{Status: http.StatusUnauthorized, Message: msg}
+}
+
+// Forbidden is the error of requests the user isn't allowed to make
+func Forbidden(msg string) HTTPError {
+	return HTTPError{Status: http.StatusForbidden, Message: msg}
+}
+
+// NotFound is the error of requests for missing resources
+func NotFound(msg string) HTTPError {
+	return HTTPError{Status: http.StatusNotFound, Message: msg}
+}
+
+// Conflict is the error of requests conflicting with the resource's state
+func Conflict(msg string) HTTPError {
+	return HTTPError{Status: http.StatusConflict, Message: msg}
+}
+
+// Validation is the error of requests containing invalid fields, with the
+// messages of the fields by name
+func Validation(fields map[string]string) HTTPError {
+	return HTTPError{Status: 422, Message: "invalid fields", Fields: fields}
+}
+
+// Internal is the error of failures within the server, where the error is only
+// logged
+func Internal(err error) HTTPError {
+	return HTTPError{Status: http.StatusInternalServerError, Err: err}
+}
+
+// Problem is the RFC 7807 problem details of an error response
+type Problem struct {
+	Type     string            `json:"type,omitempty"`
+	Title    string            `json:"title"`
+	Status   int               `json:"status"`
+	Detail   string            `json:"detail,omitempty"`
+	Instance string            `json:"instance,omitempty"`
+	Fields   map[string]string `json:"fields,omitempty"`
+}
+
+// newProblem returns the details of the error that can be shown to clients,
+// using the status for errors other than HTTPErrors and the Store's errors.
+// The messages of other errors are only shown for client errors.
+func newProblem(status int, err error) Problem {
+	switch e := err.(type) {
+	case HTTPError:
+		return Problem{Status: e.Status, Title: http.StatusText(e.Status), Detail: e.Message, Fields: e.Fields}
+	case ErrModelValidation:
+		return Problem{Status: 422, Title: "Unprocessable Entity", Detail: e.Message, Fields: e.Fields}
+	case ErrConflict, ErrDuplicateValue:
+		return Problem{Status: http.StatusConflict, Title: http.StatusText(http.StatusConflict), Detail: e.Error()}
+	case ErrTenantMismatch:
+		err = datastore.ErrNoSuchEntity
+	}
+	if err == datastore.ErrNoSuchEntity {
+		status = http.StatusNotFound
+	}
+
+	p := Problem{Status: status, Title: http.StatusText(status)}
+	if err != nil && status < 500 && err != datastore.ErrNoSuchEntity {
+		p.Detail = err.Error()
+	}
+	return p
+}
+
+// Error responds with the error's problem details, with a 500 status for
+// errors other than HTTPErrors and the Store's errors
+//  if err := postStore.Update(c, key, &post); err != nil {
+//  	h.Error(err)
+//  	return
+//  }
+func (h *Handler) Error(err error) {
+	h.respondError(http.StatusInternalServerError, err)
+}
+
+// respondError logs the error and responds with its problem details as
+// application/problem+json for API requests, or renders the error page
+func (h *Handler) respondError(status int, err error) {
+	p := h.problem(status, err)
+	if accepts(h.Req, "application/problem+json") || accepts(h.Req, "application/json") {
+		h.writeProblem(p)
+		return
+	}
+
+	// the error page can't report its own errors, which would be responded
+	// with the error page again
+	se := errorPage(p.Status, serverError(p, err))
+	if err := h.renderTemplate(errorTemplate, se, RenderOptions{Status: p.Status}); err != nil {
+		msg := p.Title
+		if se.StackTrace != "" {
+			msg += "\n\n" + se.Details + "\n" + se.StackTrace
+		}
+		http.Error(h.Res, msg, p.Status)
+	}
+}
+
+// problem logs the error and returns its problem details for the request
+func (h *Handler) problem(status int, err error) Problem {
+	p := newProblem(status, err)
+	p.Instance = h.Req.URL.Path
+	logHandlerError(h, p.Status, err)
+	return p
+}
+
+// writeProblem responds with the problem details as application/problem+json
+func (h *Handler) writeProblem(p Problem) {
+	h.Res.Header().Set("Content-Type", "application/problem+json")
+	h.Res.WriteHeader(p.Status)
+	json.NewEncoder(h.Res).Encode(p)
+}
+
+// logHandlerError logs the error along with the request and instance details,
+// which is stubbed out within tests
+var logHandlerError = func(h *Handler, status int, err error) {
+	c, cancel := context.WithCancel(h.Ctx)
+	defer cancel()
+
+	// testapp is the name given to all apps when being tested
+	var isTest = appengine.AppID(c) == "testapp"
+
+	hErr := &handlerError{
+		URL:        h.Req.URL,
+		Method:     h.Req.Method,
+		StatusCode: status,
+		AppVersion: appengine.AppID(c),
+		RequestID:  appengine.RequestID(c),
+	}
+	if err != nil {
+		hErr.Err = err.Error()
+	}
+
+	if !isTest {
+		hErr.InstanceID = appengine.InstanceID()
+		hErr.VersionID = appengine.VersionID(c)
+		hErr.ModuleName = appengine.ModuleName(c)
+	}
+
+	if status < 500 {
+		log.Warningf(c, "%s", hErr)
+	} else {
+		log.Errorf(c, "%s", hErr)
+	}
+}
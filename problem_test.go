@@ -0,0 +1,97 @@
+package ae
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine/datastore"
+)
+
+func TestError(t *testing.T) {
+	var logged []error
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {
+		logged = append(logged, err)
+	}
+
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{
+		"error.html":        `{{.Status}} {{.Message}}`,
+		"broken/error.html": `{{.Status}} {{.Missing}}`,
+	})
+
+	type test struct {
+		err         error
+		abort       int
+		accept      string
+		views       string
+		status      int
+		contentType string
+		detail      string
+		fields      bool
+	}
+
+	tests := []test{
+		test{err: NotFound("post not found"), accept: "application/json", status: 404, contentType: "application/problem+json", detail: "post not found"},
+		test{err: Validation(map[string]string{"title": "is required"}), accept: "application/problem+json", status: 422, contentType: "application/problem+json", fields: true},
+		test{err: ErrModelValidation{Message: "invalid", Fields: map[string]string{"title": "is required"}}, accept: "*/*", status: 422, contentType: "application/problem+json", fields: true},
+		test{err: ErrConflict{Version: 1, CurrentVersion: 2}, accept: "application/json", status: 409, contentType: "application/problem+json"},
+		test{err: datastore.ErrNoSuchEntity, accept: "application/json", status: 404, contentType: "application/problem+json"},
+		test{err: errors.New("secret connection string"), accept: "application/json", status: 500, contentType: "application/problem+json"},
+		test{err: Internal(errors.New("secret connection string")), accept: "application/json", status: 500, contentType: "application/problem+json"},
+		test{err: errors.New("name value required"), abort: 400, accept: "application/json", status: 400, contentType: "application/problem+json", detail: "name value required"},
+		test{err: Unauthorized(""), accept: "text/html", status: 401, contentType: "text/plain"},
+		test{err: Forbidden(""), accept: "text/html", views: dir, status: 403, contentType: "text/html", detail: "Security access fail!"},
+		test{err: errors.New("secret connection string"), views: dir, status: 500, contentType: "text/html", detail: "Something bad happened!"},
+		test{err: NotFound(""), accept: "text/html", views: filepath.Join(dir, "broken"), status: 404, contentType: "text/plain", detail: "Not Found"},
+	}
+
+	for i, test := range tests {
+		r, _ := http.NewRequest("GET", "/posts/1", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		h := NewHandler(&HandlerConfig{ViewPath: test.views})
+		h.Bind(NewMemoryContext(), w, r)
+		logged = nil
+		if test.abort != 0 {
+			h.Abort(test.abort, test.err)
+		} else {
+			h.Error(test.err)
+		}
+
+		if w.Code != test.status || !strings.HasPrefix(w.Header().Get("Content-Type"), test.contentType) {
+			t.Errorf("%d. %v: status %d %s, expected %d %s", i, test.err, w.Code, w.Header().Get("Content-Type"), test.status, test.contentType)
+			continue
+		}
+		if len(logged) != 1 || logged[0].Error() != test.err.Error() {
+			t.Errorf("%d. %v: logged %v", i, test.err, logged)
+		}
+		if strings.Contains(w.Body.String(), "secret") {
+			t.Errorf("%d. internal error exposed: %s", i, w.Body)
+		}
+		if test.contentType != "application/problem+json" {
+			if !strings.Contains(w.Body.String(), test.detail) {
+				t.Errorf("%d. body %q doesn't contain %q", i, w.Body, test.detail)
+			}
+			continue
+		}
+
+		var p Problem
+		json.NewDecoder(w.Body).Decode(&p)
+		if p.Status != test.status || p.Title == "" || p.Detail != test.detail && test.detail != "" ||
+			p.Instance != "/posts/1" || (len(p.Fields) > 0) != test.fields {
+			t.Errorf("%d. %v: invalid problem %+v", i, test.err, p)
+		}
+	}
+}
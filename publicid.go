@@ -0,0 +1,219 @@
+package ae
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrInvalidID is returned when a public ID can't be decoded into a key
+var ErrInvalidID = errors.New("invalid id")
+
+// formats of the public IDs, which differ from the first byte of the raw key
+// encodings
+const (
+	idPlain  byte = 1
+	idSealed byte = 2
+)
+
+// IDCodec converts keys to and from the public IDs that are exposed to
+// clients, which only contain the key's namespace and path, leaving out the
+// app ID. IDs are URL safe.
+type IDCodec struct {
+	aead cipher.AEAD
+	mac  []byte
+
+	// AcceptEncodedKeys allows the raw key.Encode() values, which were
+	// previously exposed, to still be decoded. Sealed codecs only accept them
+	// when it is set during the migration to the sealed IDs, as clients are
+	// able to forge raw keys.
+	AcceptEncodedKeys bool
+}
+
+// PublicIDs is the codec used by Model, Route.Key and the html helpers, which
+// can be replaced with a sealed codec during the app's initialization. The
+// raw key encodings are rejected by the sealed codec, unless they're
+// explicitly accepted while clients migrate to the sealed IDs.
+//  func init() {
+//  	ae.PublicIDs = ae.NewSealedIDCodec([]byte(os.Getenv("ID_SECRET")))
+//  	ae.PublicIDs.AcceptEncodedKeys = os.Getenv("ID_ACCEPT_KEYS") == "true" // temporarily
+//  }
+var PublicIDs = &IDCodec{AcceptEncodedKeys: true}
+
+// NewSealedIDCodec creates a codec that encrypts and authenticates the IDs
+// with the secret, to hide the kinds and IDs of the keys and prevent clients
+// from forging IDs. The same key always results in the same ID. Raw key
+// encodings aren't accepted unless AcceptEncodedKeys is set.
+func NewSealedIDCodec(secret []byte) *IDCodec {
+	sum := sha256.Sum256(secret)
+	block, err := aes.NewCipher(sum[:])
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		panic(err)
+	}
+	mac := hmac.New(sha256.New, secret)
+	io.WriteString(mac, "ae-id-nonce")
+	return &IDCodec{aead: aead, mac: mac.Sum(nil)}
+}
+
+// EncodeID returns the public ID of the key using the PublicIDs codec
+func EncodeID(key *datastore.Key) string {
+	return PublicIDs.Encode(key)
+}
+
+// DecodeID returns the key of the public ID using the PublicIDs codec
+func DecodeID(c context.Context, id string) (*datastore.Key, error) {
+	return PublicIDs.Decode(c, id)
+}
+
+// Encode returns the public ID of the key, or an empty string for nil keys
+func (x *IDCodec) Encode(key *datastore.Key) string {
+	if key == nil {
+		return ""
+	}
+	data := marshalKeyPath(key)
+	format := idPlain
+	if x.aead != nil {
+		data = x.seal(data)
+		format = idSealed
+	}
+	return base64.RawURLEncoding.EncodeToString(append([]byte{format}, data...))
+}
+
+// Decode returns the key of the public ID, which is created within the ID's
+// namespace
+func (x *IDCodec) Decode(c context.Context, id string) (*datastore.Key, error) {
+	key, err := x.decode(c, id)
+	if err != nil && x.AcceptEncodedKeys {
+		if k, e := datastore.DecodeKey(id); e == nil {
+			return k, nil
+		}
+	}
+	return key, err
+}
+
+func (x *IDCodec) decode(c context.Context, id string) (*datastore.Key, error) {
+	data, err := base64.RawURLEncoding.DecodeString(id)
+	if err != nil || len(data) == 0 {
+		return nil, ErrInvalidID
+	}
+	format, data := data[0], data[1:]
+	switch {
+	case format == idSealed && x.aead != nil:
+		if data, err = x.open(data); err != nil {
+			return nil, ErrInvalidID
+		}
+	case format != idPlain || x.aead != nil:
+		// plain IDs are rejected once the IDs are sealed
+		return nil, ErrInvalidID
+	}
+	return unmarshalKeyPath(c, data)
+}
+
+// seal encrypts the data with a nonce derived from it, so that the IDs are
+// stable
+func (x *IDCodec) seal(data []byte) []byte {
+	mac := hmac.New(sha256.New, x.mac)
+	mac.Write(data)
+	nonce := mac.Sum(nil)[:x.aead.NonceSize()]
+	return x.aead.Seal(nonce, nonce, data, nil)
+}
+
+func (x *IDCodec) open(data []byte) ([]byte, error) {
+	n := x.aead.NonceSize()
+	if len(data) < n {
+		return nil, ErrInvalidID
+	}
+	return x.aead.Open(nil, data[:n], data[n:], nil)
+}
+
+// marshalKeyPath writes the key's namespace followed by the kind and ID of
+// each of the path's keys from the root
+func marshalKeyPath(key *datastore.Key) []byte {
+	var path []*datastore.Key
+	for k := key; k != nil; k = k.Parent() {
+		path = append([]*datastore.Key{k}, path...)
+	}
+	var buf bytes.Buffer
+	writeString(&buf, key.Namespace())
+	for _, k := range path {
+		writeString(&buf, k.Kind())
+		if k.StringID() != "" {
+			buf.WriteByte(1)
+			writeString(&buf, k.StringID())
+		} else {
+			buf.WriteByte(0)
+			writeUvarint(&buf, uint64(k.IntID()))
+		}
+	}
+	return buf.Bytes()
+}
+
+func unmarshalKeyPath(c context.Context, data []byte) (*datastore.Key, error) {
+	r := bytes.NewReader(data)
+	ns, err := readString(r)
+	if err != nil {
+		return nil, ErrInvalidID
+	}
+	if c, err = appengine.Namespace(c, ns); err != nil {
+		return nil, ErrInvalidID
+	}
+	var key *datastore.Key
+	for r.Len() > 0 {
+		kind, err := readString(r)
+		if err != nil || kind == "" {
+			return nil, ErrInvalidID
+		}
+		var name string
+		var id uint64
+		switch t, _ := r.ReadByte(); t {
+		case 0:
+			id, err = binary.ReadUvarint(r)
+		case 1:
+			name, err = readString(r)
+		default:
+			err = ErrInvalidID
+		}
+		if err != nil {
+			return nil, ErrInvalidID
+		}
+		key = datastore.NewKey(c, kind, name, int64(id), key)
+	}
+	if key == nil {
+		return nil, ErrInvalidID
+	}
+	return key, nil
+}
+
+func writeUvarint(buf *bytes.Buffer, v uint64) {
+	b := make([]byte, binary.MaxVarintLen64)
+	buf.Write(b[:binary.PutUvarint(b, v)])
+}
+
+func writeString(buf *bytes.Buffer, s string) {
+	writeUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func readString(r *bytes.Reader) (string, error) {
+	n, err := binary.ReadUvarint(r)
+	if err != nil || n > uint64(r.Len()) {
+		return "", ErrInvalidID
+	}
+	b := make([]byte, n)
+	r.Read(b)
+	return string(b), nil
+}
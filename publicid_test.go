@@ -0,0 +1,101 @@
+package ae
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestPublicIDs(t *testing.T) {
+	c := NewMemoryContext()
+	nc, _ := appengine.Namespace(c, "acme")
+	parent := datastore.NewKey(c, "users", "jim", 0, nil)
+
+	type test struct {
+		name  string
+		codec *IDCodec
+		key   *datastore.Key
+	}
+
+	sealed := NewSealedIDCodec([]byte("secret"))
+	tests := []test{
+		test{name: "int id", codec: PublicIDs, key: datastore.NewKey(c, "posts", "", 42, nil)},
+		test{name: "parent", codec: PublicIDs, key: datastore.NewKey(c, "posts", "", 42, parent)},
+		test{name: "namespace", codec: PublicIDs, key: datastore.NewKey(nc, "posts", "hello", 0, nil)},
+		test{name: "incomplete", codec: PublicIDs, key: datastore.NewIncompleteKey(c, "posts", nil)},
+		test{name: "sealed", codec: sealed, key: datastore.NewKey(c, "posts", "", 42, parent)},
+	}
+
+	for _, test := range tests {
+		id := test.codec.Encode(test.key)
+		if strings.ContainsAny(id, "+/=") || (test.codec == PublicIDs && len(id) >= len(test.key.Encode())) {
+			t.Errorf("%s: id %s isn't short and URL safe", test.name, id)
+			continue
+		}
+		if test.codec.Encode(test.key) != id {
+			t.Errorf("%s: id isn't stable", test.name)
+			continue
+		}
+		key, err := test.codec.Decode(c, id)
+		if err != nil || !key.Equal(test.key) {
+			t.Errorf("%s: decoded %v, expected %v: %v", test.name, key, test.key, err)
+		}
+	}
+
+	key := datastore.NewKey(c, "posts", "", 42, nil)
+	if strings.Contains(sealed.Encode(key), PublicIDs.Encode(key)[1:]) {
+		t.Error("sealed id contains the plain path")
+	}
+
+	// the raw encodings are only accepted when opted in during the migration
+	migrating := NewSealedIDCodec([]byte("secret"))
+	migrating.AcceptEncodedKeys = true
+	if k, err := migrating.Decode(c, key.Encode()); err != nil || !k.Equal(key) {
+		t.Errorf("raw encoding not decoded: %v", err)
+	}
+
+	invalid := []string{
+		"",
+		"not an id",
+		key.Encode(),
+		PublicIDs.Encode(key),
+		NewSealedIDCodec([]byte("other")).Encode(key),
+		sealed.Encode(key)[:10],
+	}
+	for _, id := range invalid {
+		if _, err := sealed.Decode(c, id); err != ErrInvalidID {
+			t.Errorf("%q decoded: %v", id, err)
+		}
+	}
+}
+
+func TestLegacyModelJSON(t *testing.T) {
+	c := NewMemoryContext()
+	key := datastore.NewKey(c, "posts", "", 42, nil)
+
+	type test struct {
+		body string
+	}
+
+	tests := []test{
+		test{body: `{"key": "` + key.Encode() + `", "title": "foo"}`},
+		test{body: `{"key": "` + EncodeID(key) + `", "title": "foo"}`},
+	}
+
+	for _, test := range tests {
+		var p resourcePost
+		if err := json.Unmarshal([]byte(test.body), &p); err != nil || p.Title != "foo" {
+			t.Errorf("%s: decoded %+v: %v", test.body, p, err)
+			continue
+		}
+		if p.Key != nil {
+			t.Errorf("%s: key decoded from json", test.body)
+		}
+		if k, err := DecodeID(c, p.ID); err != nil || !k.Equal(key) {
+			t.Errorf("%s: id decoded to %v, expected %v: %v", test.body, k, key, err)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package compress
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/chrisolsen/ae/que"
+	"golang.org/x/net/context"
+)
+
+// the size of responses below which they aren't compressed when none is set
+const defaultMinSize = 1024
+
+// the content types compressed when none are set, which excludes the already
+// compressed images and archives
+var defaultTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/javascript",
+	"image/svg+xml",
+}
+
+// Options contains the optional settings of the compression
+type Options struct {
+	// MinSize is the number of bytes below which responses aren't compressed,
+	// which defaults to 1024
+	MinSize int
+
+	// Level of the compression, which defaults to the default compression of
+	// the flate package
+	Level int
+
+	// Types are the patterns of the content types compressed, in the format of
+	// path.Match, which defaults to text, JSON, XML, JavaScript and SVG
+	Types []string
+}
+
+// Responses returns a wrapper that compresses the responses with gzip or
+// deflate, as negotiated with the Accept-Encoding header. Strong ETags of the
+// compressed responses are suffixed with the encoding, which is removed from
+// the conditional headers of requests for the ETags to still match.
+// Responses that are flushed before reaching the MinSize, such as event
+// streams, aren't compressed.
+//  q := que.New(auth.VerifyReferrer)
+//  q.Wrap(compress.Responses(compress.Options{}))
+func Responses(opts Options) que.Wrapper {
+	if opts.MinSize <= 0 {
+		opts.MinSize = defaultMinSize
+	}
+	if opts.Level == 0 {
+		opts.Level = flate.DefaultCompression
+	}
+	if opts.Types == nil {
+		opts.Types = defaultTypes
+	}
+	return func(next func(context.Context, http.ResponseWriter, *http.Request)) func(context.Context, http.ResponseWriter, *http.Request) {
+		return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			cw := &compressWriter{
+				ResponseWriter: w,
+				opts:           opts,
+				encoding:       negotiate(r.Header.Get("Accept-Encoding")),
+			}
+			for _, name := range []string{"If-None-Match", "If-Match"} {
+				if tags := r.Header.Get(name); tags != "" {
+					stripped := stripEncodings(tags)
+					cw.stripped = cw.stripped || stripped != tags
+					r.Header.Set(name, stripped)
+				}
+			}
+			defer cw.Close()
+			next(c, cw, r)
+		}
+	}
+}
+
+// negotiate returns the accepted encoding with the highest quality, preferring
+// gzip, or an empty string if neither are accepted
+func negotiate(acceptEncoding string) string {
+	var encoding string
+	var best float64
+	qualities := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		params := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(params[0]))
+		q := 1.0
+		for _, p := range params[1:] {
+			p = strings.TrimSpace(p)
+			if strings.HasPrefix(p, "q=") {
+				if v, err := strconv.ParseFloat(p[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		qualities[name] = q
+	}
+	for _, name := range []string{"gzip", "deflate"} {
+		q, ok := qualities[name]
+		if !ok {
+			q = qualities["*"]
+		}
+		if q > best {
+			encoding, best = name, q
+		}
+	}
+	return encoding
+}
+
+// stripEncodings removes the encoding suffixes of the ETags
+func stripEncodings(tags string) string {
+	return strings.NewReplacer(`-gzip"`, `"`, `-deflate"`, `"`).Replace(tags)
+}
+
+// compressWriter buffers the response until it reaches the minimum size, or is
+// flushed, to decide whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	opts     Options
+	encoding string
+	stripped bool
+
+	status  int
+	buf     []byte
+	decided bool
+	writer  io.WriteCloser
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, b...)
+		if len(cw.buf) < cw.opts.MinSize {
+			return len(b), nil
+		}
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+	if cw.writer != nil {
+		return cw.writer.Write(b)
+	}
+	return cw.ResponseWriter.Write(b)
+}
+
+// decide sets the headers of the response, based on whether it is compressed,
+// and writes the buffered body
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	header := cw.Header()
+	compressible := cw.compressible()
+	if compressible {
+		addVary(header, "Accept-Encoding")
+	}
+
+	if cw.status == http.StatusNotModified && cw.stripped && cw.encoding != "" {
+		// the client's ETag had the suffix removed
+		header.Set("ETag", suffixETag(header.Get("ETag"), cw.encoding))
+	}
+
+	if compressible && cw.encoding != "" && len(cw.buf) >= cw.opts.MinSize &&
+		header.Get("Content-Encoding") == "" && cw.status != http.StatusNoContent && cw.status != http.StatusNotModified {
+		header.Set("Content-Encoding", cw.encoding)
+		header.Del("Content-Length")
+		header.Set("ETag", suffixETag(header.Get("ETag"), cw.encoding))
+		var err error
+		if cw.encoding == "gzip" {
+			cw.writer, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.opts.Level)
+		} else {
+			cw.writer, err = flate.NewWriter(cw.ResponseWriter, cw.opts.Level)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if cw.status != 0 {
+		cw.ResponseWriter.WriteHeader(cw.status)
+	}
+	if len(cw.buf) == 0 {
+		return nil
+	}
+	buf := cw.buf
+	cw.buf = nil
+	if cw.writer != nil {
+		_, err := cw.writer.Write(buf)
+		return err
+	}
+	_, err := cw.ResponseWriter.Write(buf)
+	return err
+}
+
+// compressible indicates if the content type, or the sniffed type of the body
+// if it isn't set, matches one of the compressed types
+func (cw *compressWriter) compressible() bool {
+	contentType := cw.Header().Get("Content-Type")
+	if contentType == "" {
+		if len(cw.buf) == 0 {
+			return false
+		}
+		contentType = http.DetectContentType(cw.buf)
+		cw.Header().Set("Content-Type", contentType)
+	}
+	mediaType := strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	for _, pattern := range cw.opts.Types {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Flush writes the buffered response, which isn't compressed if it is below
+// the minimum size, along with the compressed data
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		cw.decide()
+	}
+	if gw, ok := cw.writer.(*gzip.Writer); ok {
+		gw.Flush()
+	} else if fw, ok := cw.writer.(*flate.Writer); ok {
+		fw.Flush()
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close writes the remaining response once the handler is done
+func (cw *compressWriter) Close() error {
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
+	}
+	return nil
+}
+
+// CloseNotify notifies when the client disconnects, if the underlying writer
+// supports it
+func (cw *compressWriter) CloseNotify() <-chan bool {
+	if cn, ok := cw.ResponseWriter.(http.CloseNotifier); ok {
+		return cn.CloseNotify()
+	}
+	return nil
+}
+
+// Hijack takes over the connection of the underlying writer
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := cw.ResponseWriter.(http.Hijacker); ok {
+		cw.decided = true
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("compress: the response writer can't be hijacked")
+}
+
+// suffixETag appends the encoding to strong ETags, which differ for each
+// encoding of the response
+func suffixETag(etag, encoding string) string {
+	if !strings.HasPrefix(etag, `"`) || !strings.HasSuffix(etag, `"`) || len(etag) < 2 ||
+		strings.HasSuffix(etag, "-"+encoding+`"`) {
+		return etag
+	}
+	return etag[:len(etag)-1] + "-" + encoding + `"`
+}
+
+func addVary(header http.Header, name string) {
+	for _, v := range header["Vary"] {
+		for _, field := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), name) {
+				return
+			}
+		}
+	}
+	header.Add("Vary", name)
+}
@@ -0,0 +1,108 @@
+package compress
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/chrisolsen/ae/que"
+	"golang.org/x/net/context"
+)
+
+func TestResponses(t *testing.T) {
+	large := strings.Repeat(`{"title": "foo"}`, 100)
+
+	type test struct {
+		acceptEncoding string
+		ifNoneMatch    string
+		contentType    string
+		contentEnc     string
+		etag           string
+		body           string
+		status         int
+		encoding       string
+		vary           bool
+		responseETag   string
+	}
+
+	tests := []test{
+		test{acceptEncoding: "gzip, deflate", contentType: "application/json", body: large, status: 200, encoding: "gzip", vary: true},
+		test{acceptEncoding: "deflate", contentType: "application/json", body: large, status: 200, encoding: "deflate", vary: true},
+		test{acceptEncoding: "gzip;q=0, deflate", contentType: "text/html", body: large, status: 200, encoding: "deflate", vary: true},
+		test{acceptEncoding: "*", contentType: "text/csv; charset=utf-8", body: large, status: 200, encoding: "gzip", vary: true},
+		test{acceptEncoding: "identity", contentType: "application/json", body: large, status: 200, vary: true},
+		test{contentType: "application/json", body: large, status: 200, vary: true},
+		test{acceptEncoding: "gzip", contentType: "application/json", body: `{"title": "foo"}`, status: 200, vary: true},
+		test{acceptEncoding: "gzip", contentType: "image/png", body: large, status: 200},
+		test{acceptEncoding: "gzip", body: "<html><body>" + large, status: 200, encoding: "gzip", vary: true},
+		test{acceptEncoding: "gzip", contentType: "application/json", contentEnc: "br", body: large, status: 200, vary: true},
+		test{acceptEncoding: "gzip", contentType: "application/json", etag: `"abc"`, body: large, status: 200, encoding: "gzip", vary: true, responseETag: `"abc-gzip"`},
+		test{acceptEncoding: "gzip", contentType: "application/json", etag: `W/"abc"`, body: large, status: 200, encoding: "gzip", vary: true, responseETag: `W/"abc"`},
+		test{acceptEncoding: "gzip", contentType: "application/json", etag: `"abc"`, body: `{}`, status: 200, vary: true, responseETag: `"abc"`},
+		test{acceptEncoding: "gzip", ifNoneMatch: `"abc-gzip"`, contentType: "application/json", etag: `"abc"`, body: large, status: 304, responseETag: `"abc-gzip"`},
+		test{acceptEncoding: "gzip", ifNoneMatch: `"abc"`, contentType: "application/json", etag: `"abc"`, body: large, status: 304, responseETag: `"abc"`},
+	}
+
+	for i, test := range tests {
+		// the handler is run as middleware, since HandleFunc requires an App
+		// Engine request
+		q := que.New(func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+			if test.contentType != "" {
+				w.Header().Set("Content-Type", test.contentType)
+			}
+			if test.contentEnc != "" {
+				w.Header().Set("Content-Encoding", test.contentEnc)
+			}
+			if test.etag != "" {
+				w.Header().Set("ETag", test.etag)
+				if r.Header.Get("If-None-Match") == test.etag {
+					w.Header().Del("Content-Type")
+					w.WriteHeader(http.StatusNotModified)
+					return c
+				}
+			}
+			// written in parts to be buffered
+			io.WriteString(w, test.body[:len(test.body)/2])
+			io.WriteString(w, test.body[len(test.body)/2:])
+			return c
+		})
+		q.Wrap(Responses(Options{}))
+
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", test.acceptEncoding)
+		r.Header.Set("If-None-Match", test.ifNoneMatch)
+		w := httptest.NewRecorder()
+		q.Run(context.Background(), w, r)
+
+		if w.Code != test.status || w.Header().Get("Content-Encoding") != test.encoding && test.contentEnc == "" {
+			t.Errorf("%d. status %d encoding %s, expected %d %s", i, w.Code, w.Header().Get("Content-Encoding"), test.status, test.encoding)
+			continue
+		}
+		if vary := w.Header().Get("Vary") == "Accept-Encoding"; vary != test.vary {
+			t.Errorf("%d. vary %v, expected %v", i, vary, test.vary)
+		}
+		if etag := w.Header().Get("ETag"); etag != test.responseETag {
+			t.Errorf("%d. etag %s, expected %s", i, etag, test.responseETag)
+		}
+		if test.status == http.StatusNotModified {
+			continue
+		}
+
+		var body io.Reader = w.Body
+		switch test.encoding {
+		case "gzip":
+			body, _ = gzip.NewReader(w.Body)
+		case "deflate":
+			body = flate.NewReader(w.Body)
+		}
+		b, err := ioutil.ReadAll(body)
+		if err != nil || string(b) != test.body {
+			t.Errorf("%d. body %.20q, expected %.20q: %v", i, b, test.body, err)
+		}
+	}
+}
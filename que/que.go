@@ -10,6 +10,12 @@ import (
 // Middleware is a http.HandlerFunc that also includes a context and url params variables
 type Middleware func(context.Context, http.ResponseWriter, *http.Request) context.Context
 
+// Wrapper wraps the rest of the chain, including the other middleware, which
+// allows code to be run after the handler, such as recovering its panics.
+// Unnamed function types are used for packages to provide wrappers without
+// depending on que.
+type Wrapper func(next func(context.Context, http.ResponseWriter, *http.Request)) func(context.Context, http.ResponseWriter, *http.Request)
+
 // HandlerFunc much like the standard http.HandlerFunc, but includes the request context
 type HandlerFunc func(context.Context, http.ResponseWriter, *http.Request)
 
@@ -21,8 +27,9 @@ type Handler interface {
 
 // Q allows a list middleware functions to be created and run
 type Q struct {
-	ops     []Middleware
-	handler Handler
+	ops      []Middleware
+	wrappers []Wrapper
+	handler  Handler
 }
 
 // New initializes the middleware chain with one or more handler functions.
@@ -43,6 +50,14 @@ func (q *Q) Add(ops ...Middleware) {
 	q.ops = append(q.ops, ops...)
 }
 
+// Wrap adds one or more wrappers around the chain, with the first being the
+// outermost
+//	q := que.New(foo, bar)
+//	q.Wrap(ae.Recover(config))
+func (q *Q) Wrap(wrappers ...Wrapper) {
+	q.wrappers = append(q.wrappers, wrappers...)
+}
+
 // Run executes the handler chain, which is most useful in tests
 //	q := que.New(foo, bar)
 // 	q.Add(func(c context.Context, w http.ResponseWriter, r *http.Request) {
@@ -54,12 +69,7 @@ func (q *Q) Add(ops ...Middleware) {
 // 	c := appengine.NewContext(r)
 // 	q.Run(c, w, r)
 func (q *Q) Run(c context.Context, w http.ResponseWriter, r *http.Request) {
-	for _, op := range q.ops {
-		c = op(c, w, r)
-		if c.Err() != nil {
-			return
-		}
-	}
+	chain(q.ops, q.wrappers, nil)(c, w, r)
 }
 
 // HandleFunc returns the chain of existing middleware that includes the final HandlerFunc argument.
@@ -67,14 +77,7 @@ func (q *Q) Run(c context.Context, w http.ResponseWriter, r *http.Request) {
 //  router.Get("/", q.HandleFunc(handleRoot))
 func (q *Q) HandleFunc(fn HandlerFunc) func(http.ResponseWriter, *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		c := appengine.NewContext(r)
-		for _, op := range q.ops {
-			c = op(c, w, r)
-			if c.Err() != nil {
-				return
-			}
-		}
-		fn(c, w, r)
+		chain(q.ops, q.wrappers, fn)(appengine.NewContext(r), w, r)
 	}
 }
 
@@ -83,22 +86,36 @@ func (q *Q) HandleFunc(fn HandlerFunc) func(http.ResponseWriter, *http.Request)
 //	q := que.New(foo, bar)
 //  router.Get("/", q.Handle(handleRoot))
 func (q *Q) Handle(h Handler) http.Handler {
-	return handler{ops: q.ops, handler: h}
+	return handler{ops: q.ops, wrappers: q.wrappers, handler: h}
 }
 
 // handler allows the middleware calls to be wrapped up into a Handler interface
 type handler struct {
-	ops     []Middleware
-	handler Handler
+	ops      []Middleware
+	wrappers []Wrapper
+	handler  Handler
 }
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	c := appengine.NewContext(r)
-	for _, op := range h.ops {
-		c = op(c, w, r)
-		if c.Err() != nil {
-			return
+	chain(h.ops, h.wrappers, h.handler.ServeHTTP)(appengine.NewContext(r), w, r)
+}
+
+// chain returns the function running the middleware followed by the handler,
+// if the middleware didn't cancel the context, within the wrappers
+func chain(ops []Middleware, wrappers []Wrapper, fn HandlerFunc) func(context.Context, http.ResponseWriter, *http.Request) {
+	run := func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		for _, op := range ops {
+			c = op(c, w, r)
+			if c.Err() != nil {
+				return
+			}
 		}
+		if fn != nil {
+			fn(c, w, r)
+		}
+	}
+	for i := len(wrappers) - 1; i >= 0; i-- {
+		run = wrappers[i](run)
 	}
-	h.handler.ServeHTTP(c, w, r)
+	return run
 }
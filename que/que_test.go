@@ -2,6 +2,7 @@ package que
 
 import (
 	"net/http"
+	"strings"
 	"testing"
 
 	"golang.org/x/net/context"
@@ -60,3 +61,29 @@ func Test_NoMiddleware(t *testing.T) {
 	})
 	q.Run(c, nil, nil)
 }
+
+func Test_Wrap(t *testing.T) {
+	var calls []string
+	wrapper := func(name string) Wrapper {
+		return func(next func(context.Context, http.ResponseWriter, *http.Request)) func(context.Context, http.ResponseWriter, *http.Request) {
+			return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+				calls = append(calls, name+" before")
+				next(c, w, r)
+				calls = append(calls, name+" after")
+			}
+		}
+	}
+	mw := func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		calls = append(calls, "middleware")
+		return c
+	}
+
+	q := New(mw)
+	q.Wrap(wrapper("outer"), wrapper("inner"))
+	q.Run(context.Background(), nil, nil)
+
+	expected := "outer before,inner before,middleware,inner after,outer after"
+	if actual := strings.Join(calls, ","); actual != expected {
+		t.Errorf("called %s, expected %s", actual, expected)
+	}
+}
@@ -0,0 +1,185 @@
+package ae
+
+import (
+	"errors"
+	"strings"
+
+	"google.golang.org/appengine/datastore"
+)
+
+// ErrInvalidCursor is returned when a query's start or end cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid query cursor")
+
+// Query describes a datastore query independently of the backend that runs it.
+// Like datastore.Query, each method returns a modified copy of the query.
+type Query struct {
+	kind     string
+	ancestor *datastore.Key
+	filters  []queryFilter
+	orders   []queryOrder
+	offset   int
+	limit    int
+	keysOnly bool
+	start    string
+	end      string
+	hydrate  bool
+
+	withDeleted bool
+}
+
+type queryFilter struct {
+	field string
+	op    string
+	value interface{}
+}
+
+type queryOrder struct {
+	field string
+	desc  bool
+}
+
+// NewQuery creates a new query for the kind
+func NewQuery(kind string) *Query {
+	return &Query{kind: kind, limit: -1}
+}
+
+func (q *Query) clone() *Query {
+	x := *q
+	x.filters = append([]queryFilter(nil), q.filters...)
+	x.orders = append([]queryOrder(nil), q.orders...)
+	return &x
+}
+
+// Kind returns the kind the query runs against
+func (q *Query) Kind() string {
+	return q.kind
+}
+
+// Ancestor limits the results to the descendants of the key
+func (q *Query) Ancestor(key *datastore.Key) *Query {
+	q = q.clone()
+	q.ancestor = key
+	return q
+}
+
+// Filter adds a property filter in the same format as datastore.Query.Filter
+//  q.Filter("Username =", "jim")
+func (q *Query) Filter(filterStr string, value interface{}) *Query {
+	q = q.clone()
+	filterStr = strings.TrimSpace(filterStr)
+	op := "="
+	for _, o := range []string{"<=", ">=", "<", ">", "="} {
+		if strings.HasSuffix(filterStr, o) {
+			op = o
+			break
+		}
+	}
+	field := strings.TrimSpace(strings.TrimSuffix(filterStr, op))
+	q.filters = append(q.filters, queryFilter{field: field, op: op, value: value})
+	return q
+}
+
+// Order sorts the results by the field, a leading `-` sorts in descending order
+func (q *Query) Order(fieldName string) *Query {
+	q = q.clone()
+	fieldName = strings.TrimSpace(fieldName)
+	o := queryOrder{field: fieldName}
+	if strings.HasPrefix(fieldName, "-") {
+		o.field, o.desc = strings.TrimSpace(fieldName[1:]), true
+	}
+	q.orders = append(q.orders, o)
+	return q
+}
+
+// Offset skips the first n results
+func (q *Query) Offset(offset int) *Query {
+	q = q.clone()
+	q.offset = offset
+	return q
+}
+
+// Limit limits the number of results, a negative value means unlimited
+func (q *Query) Limit(limit int) *Query {
+	q = q.clone()
+	q.limit = limit
+	return q
+}
+
+// KeysOnly results in only the keys being returned
+func (q *Query) KeysOnly() *Query {
+	q = q.clone()
+	q.keysOnly = true
+	return q
+}
+
+// Start begins the query at the cursor returned with a previous page
+func (q *Query) Start(cursor string) *Query {
+	q = q.clone()
+	q.start = cursor
+	return q
+}
+
+// End stops the query at the cursor returned with a previous page
+func (q *Query) End(cursor string) *Query {
+	q = q.clone()
+	q.end = cursor
+	return q
+}
+
+// Hydrate runs the query as keys only, with the models then being fetched
+// through the Store's cached GetMulti. This is most effective for queries
+// that return models that are frequently read.
+func (q *Query) Hydrate() *Query {
+	q = q.clone()
+	q.hydrate = true
+	return q
+}
+
+// WithDeleted includes the soft deleted models within the results
+func (q *Query) WithDeleted() *Query {
+	q = q.clone()
+	q.withDeleted = true
+	return q
+}
+
+// datastoreQuery converts the query into its App Engine equivalent
+func (q *Query) datastoreQuery() (*datastore.Query, error) {
+	dq := datastore.NewQuery(q.kind)
+	if q.ancestor != nil {
+		dq = dq.Ancestor(q.ancestor)
+	}
+	for _, f := range q.filters {
+		dq = dq.Filter(f.field+" "+f.op, f.value)
+	}
+	for _, o := range q.orders {
+		if o.desc {
+			dq = dq.Order("-" + o.field)
+		} else {
+			dq = dq.Order(o.field)
+		}
+	}
+	if q.offset > 0 {
+		dq = dq.Offset(q.offset)
+	}
+	if q.limit >= 0 {
+		dq = dq.Limit(q.limit)
+	}
+	if q.keysOnly {
+		dq = dq.KeysOnly()
+	}
+	if q.start != "" {
+		cursor, err := datastore.DecodeCursor(q.start)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		dq = dq.Start(cursor)
+	}
+	if q.end != "" {
+		cursor, err := datastore.DecodeCursor(q.end)
+		if err != nil {
+			return nil, ErrInvalidCursor
+		}
+		dq = dq.End(cursor)
+	}
+	return dq, nil
+}
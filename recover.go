@@ -0,0 +1,118 @@
+package ae
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+)
+
+// the number of lines shown before and after the line that panicked
+const sourceContextLines = 5
+
+// allows the dev server details to be tested
+var isDevAppServer = appengine.IsDevAppServer
+
+// panicError is the error of a recovered panic
+type panicError struct {
+	value interface{}
+	stack []byte
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("panic: %v\n%s", e.value, e.stack)
+}
+
+// Recover returns a que.Wrapper that recovers the panics of the chain, logging
+// them with the stack and request ID, and responding with the error page, or
+// problem details for API requests. The stack trace and the source of the line
+// that panicked are only shown on the dev app server.
+//  q := que.New(auth.VerifyReferrer)
+//  q.Wrap(ae.Recover(config))
+func Recover(config HandlerConfig) func(next func(context.Context, http.ResponseWriter, *http.Request)) func(context.Context, http.ResponseWriter, *http.Request) {
+	return func(next func(context.Context, http.ResponseWriter, *http.Request)) func(context.Context, http.ResponseWriter, *http.Request) {
+		return func(c context.Context, w http.ResponseWriter, r *http.Request) {
+			defer func() {
+				if p := recover(); p != nil {
+					h := NewHandler(&config)
+					h.Bind(c, w, r)
+					h.Error(panicError{value: p, stack: debug.Stack()})
+				}
+			}()
+			next(c, w, r)
+		}
+	}
+}
+
+// serverError returns the details of the error page, which only includes the
+// stack trace and source of panics on the dev app server
+func serverError(p Problem, err error) *ServerError {
+	se := &ServerError{Message: p.Detail}
+	if e, ok := err.(panicError); ok && isDevAppServer() {
+		se.StackTrace = string(e.stack)
+		if file, line := panicLocation(e.stack); file != "" {
+			se.Details = sourceContext(file, line, sourceContextLines)
+		}
+	}
+	return se
+}
+
+// panicLocation returns the file and line of the first function outside the
+// runtime that was called before panic within the stack trace
+func panicLocation(stack []byte) (string, int) {
+	lines := strings.Split(string(stack), "\n")
+	for i, l := range lines {
+		if !strings.HasPrefix(l, "panic(") {
+			continue
+		}
+		// each frame is the function followed by its indented location
+		for j := i + 2; j+1 < len(lines); j += 2 {
+			if strings.HasPrefix(lines[j], "runtime.") {
+				continue
+			}
+			loc := strings.TrimSpace(lines[j+1])
+			if sp := strings.LastIndex(loc, " +0x"); sp > 0 {
+				loc = loc[:sp]
+			}
+			colon := strings.LastIndex(loc, ":")
+			if colon < 0 {
+				return "", 0
+			}
+			line, err := strconv.Atoi(loc[colon+1:])
+			if err != nil {
+				return "", 0
+			}
+			return loc[:colon], line
+		}
+	}
+	return "", 0
+}
+
+// sourceContext returns the numbered lines surrounding the line of the file,
+// with the line itself marked
+func sourceContext(file string, line, n int) string {
+	src, err := ioutil.ReadFile(file)
+	if err != nil {
+		return ""
+	}
+	lines := strings.Split(string(src), "\n")
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "%s:%d\n", file, line)
+	for i := line - n; i <= line+n; i++ {
+		if i < 1 || i > len(lines) {
+			continue
+		}
+		marker := " "
+		if i == line {
+			marker = ">"
+		}
+		fmt.Fprintf(&buf, "%s%5d  %s\n", marker, i, lines[i-1])
+	}
+	return buf.String()
+}
@@ -0,0 +1,81 @@
+package ae
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestRecover(t *testing.T) {
+	var logged []error
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {
+		logged = append(logged, err)
+	}
+	defer func(fn func() bool) { isDevAppServer = fn }(isDevAppServer)
+
+	dir, err := ioutil.TempDir("", "views")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{"error.html": `{{.Message}}|{{.Details}}|{{.StackTrace}}`})
+
+	handler := func(c context.Context, w http.ResponseWriter, r *http.Request) {
+		var posts map[string]int
+		posts["foo"]++ // panics with a nil map
+	}
+
+	type test struct {
+		accept   string
+		dev      bool
+		contains []string
+		excludes []string
+	}
+
+	tests := []test{
+		test{accept: "text/html", contains: []string{"Something bad happened!"}, excludes: []string{"goroutine", "recover_test.go"}},
+		test{accept: "text/html", dev: true, contains: []string{"goroutine", "&gt;", "panics with a nil map"}},
+		test{accept: "application/json", dev: true, contains: []string{`"status":500`}, excludes: []string{"goroutine", "nil map"}},
+	}
+
+	for i, test := range tests {
+		isDevAppServer = func() bool { return test.dev }
+		r, _ := http.NewRequest("GET", "/posts", nil)
+		r.Header.Set("Accept", test.accept)
+		w := httptest.NewRecorder()
+		logged = nil
+		Recover(HandlerConfig{ViewPath: dir})(handler)(NewMemoryContext(), w, r)
+
+		if w.Code != http.StatusInternalServerError || len(logged) != 1 || !strings.Contains(logged[0].Error(), "nil map") {
+			t.Errorf("%d. status %d, logged %v", i, w.Code, logged)
+			continue
+		}
+		if !strings.Contains(logged[0].Error(), "goroutine") {
+			t.Errorf("%d. stack not logged: %v", i, logged[0])
+		}
+		body := w.Body.String()
+		for _, s := range test.contains {
+			if !strings.Contains(body, s) {
+				t.Errorf("%d. body doesn't contain %q: %s", i, s, body)
+			}
+		}
+		for _, s := range test.excludes {
+			if strings.Contains(body, s) {
+				t.Errorf("%d. body contains %q: %s", i, s, body)
+			}
+		}
+		if test.accept == "application/json" {
+			var p Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Status != 500 {
+				t.Errorf("%d. invalid problem %s", i, body)
+			}
+		}
+	}
+}
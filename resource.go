@@ -0,0 +1,370 @@
+package ae
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+// Resource actions passed to the authorization policy
+const (
+	ResourceList   = "list"
+	ResourceShow   = "show"
+	ResourceCreate = "create"
+	ResourceUpdate = "update"
+	ResourceDelete = "delete"
+)
+
+// the page size of lists when neither the resource or request sets one
+const defaultPageSize = 20
+
+// Resource serves the JSON REST endpoints of a store's models, which are
+// identified by their public IDs.
+//  GET    /posts?cursor=&limit=&Author=  lists the models
+//  POST   /posts                         creates a model
+//  GET    /posts/:key                    shows the model
+//  PUT    /posts/:key                    replaces the model
+//  PATCH  /posts/:key                    updates the model's passed fields
+//  DELETE /posts/:key                    deletes the model
+//
+//  posts := ae.Resource{Path: "/posts", Store: postStore, Model: &Post{}, Filters: []string{"Author"}}
+//  router.Handle("/posts/*", q.Handle(posts))
+type Resource struct {
+	// Path of the collection, that the model keys are appended to
+	Path string
+
+	Store Store
+
+	// Model is a pointer to a zero value of the model, which defaults to the
+	// store's Model
+	Model interface{}
+
+	// Authorize is the policy called before each action, with the key being
+	// nil for list and create actions. Requests are forbidden if it returns
+	// false, or allowed if it isn't set.
+	Authorize func(c context.Context, r *http.Request, action string, key *datastore.Key) bool
+
+	// Filters are the names of the model's fields that lists can be filtered
+	// by equality, through the query string
+	Filters []string
+
+	// Order of the listed models, in the same format as Query.Order
+	Order string
+
+	// PageSize is the default and maximum number of models listed per page
+	PageSize int
+
+	// MaxBodyBytes is the largest request body read, which defaults to
+	// DefaultMaxBodyBytes
+	MaxBodyBytes int64
+}
+
+// resourceList is the response of list requests
+type resourceList struct {
+	Items  interface{} `json:"items"`
+	Cursor string      `json:"cursor"`
+}
+
+func (rs Resource) ServeHTTP(c context.Context, w http.ResponseWriter, r *http.Request) {
+	var h Handler
+	h.Bind(c, w, r)
+	h.config.MaxBodyBytes = rs.MaxBodyBytes
+	route := NewRoute(r)
+	collection := strings.TrimSuffix(rs.Path, "/")
+	switch {
+	case route.Matches("GET", collection):
+		if rs.authorize(&h, ResourceList, nil) {
+			rs.list(&h)
+		}
+	case route.Matches("POST", collection):
+		if rs.authorize(&h, ResourceCreate, nil) {
+			rs.create(&h)
+		}
+	case route.MatchesPath(collection + "/:key"):
+		key := rs.key(&route)
+		if key == nil {
+			rs.error(&h, http.StatusNotFound, datastore.ErrNoSuchEntity)
+			return
+		}
+		switch r.Method {
+		case "GET":
+			if rs.authorize(&h, ResourceShow, key) {
+				rs.show(&h, key)
+			}
+		case "PUT", "PATCH":
+			if rs.authorize(&h, ResourceUpdate, key) {
+				rs.update(&h, key, r.Method == "PATCH")
+			}
+		case "DELETE":
+			if rs.authorize(&h, ResourceDelete, key) {
+				rs.delete(&h, key)
+			}
+		default:
+			rs.error(&h, http.StatusMethodNotAllowed, nil)
+		}
+	default:
+		rs.error(&h, http.StatusNotFound, nil)
+	}
+}
+
+func (rs Resource) authorize(h *Handler, action string, key *datastore.Key) bool {
+	if rs.Authorize == nil || rs.Authorize(h.Ctx, h.Req, action, key) {
+		return true
+	}
+	rs.error(h, http.StatusForbidden, nil)
+	return false
+}
+
+// key decodes the route's key, returning nil for invalid keys or the keys of
+// other kinds
+func (rs Resource) key(route *Route) *datastore.Key {
+	key := route.Key("key")
+	if key == nil || key.Kind() != rs.Store.TableName {
+		return nil
+	}
+	return key
+}
+
+func (rs Resource) modelType() reflect.Type {
+	model := rs.Model
+	if model == nil {
+		model = rs.Store.Model
+	}
+	return reflect.TypeOf(model).Elem()
+}
+
+func (rs Resource) newModel() interface{} {
+	return reflect.New(rs.modelType()).Interface()
+}
+
+// GET /:path
+func (rs Resource) list(h *Handler) {
+	params := h.Req.URL.Query()
+	q := rs.Store.Query().Start(params.Get("cursor"))
+	if rs.Order != "" {
+		q = q.Order(rs.Order)
+	}
+	for _, name := range rs.Filters {
+		if _, ok := params[name]; !ok {
+			continue
+		}
+		value, err := rs.filterValue(h.Ctx, name, params.Get(name))
+		if err != nil {
+			rs.error(h, http.StatusBadRequest, err)
+			return
+		}
+		q = q.Filter(name+" =", value)
+	}
+
+	pageSize := rs.PageSize
+	if pageSize <= 0 {
+		pageSize = defaultPageSize
+	}
+	limit := pageSize
+	if l, err := strconv.Atoi(params.Get("limit")); err == nil && l > 0 && l < pageSize {
+		limit = l
+	}
+
+	items := reflect.New(reflect.SliceOf(reflect.PtrTo(rs.modelType())))
+	items.Elem().Set(reflect.MakeSlice(items.Elem().Type(), 0, limit))
+	page, err := rs.Store.GetPage(h.Ctx, q.Limit(limit), items.Interface())
+	if err != nil {
+		rs.storeError(h, err)
+		return
+	}
+	h.ToJSON(resourceList{Items: items.Elem().Interface(), Cursor: page.Cursor})
+}
+
+// filterValue converts the query string value to the type of the model's field
+func (rs Resource) filterValue(c context.Context, name, value string) (interface{}, error) {
+	f, ok := rs.modelType().FieldByName(name)
+	if !ok {
+		return value, nil
+	}
+	var v interface{}
+	var err error
+	switch f.Type.Kind() {
+	case reflect.Bool:
+		v, err = strconv.ParseBool(value)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v, err = strconv.ParseInt(value, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		v, err = strconv.ParseFloat(value, 64)
+	case reflect.Ptr:
+		if f.Type == reflect.TypeOf(&datastore.Key{}) {
+			v, err = DecodeID(c, value)
+		} else {
+			v = value
+		}
+	default:
+		v = value
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s filter %q", name, value)
+	}
+	return v, nil
+}
+
+// GET /:path/:key
+func (rs Resource) show(h *Handler, key *datastore.Key) {
+	model := rs.newModel()
+	if _, err := rs.Store.Get(h.Ctx, key, model); err != nil {
+		rs.storeError(h, err)
+		return
+	}
+	rs.setValidators(h, model)
+	if rs.checkPreconditions(h) {
+		h.ToJSON(model)
+	}
+}
+
+// POST /:path
+func (rs Resource) create(h *Handler) {
+	body, ok := rs.readBody(h)
+	if !ok {
+		return
+	}
+	model := rs.newModel()
+	if err := json.Unmarshal(body, model); err != nil {
+		rs.error(h, http.StatusBadRequest, fmt.Errorf("invalid JSON: %v", err))
+		return
+	}
+	key, err := rs.Store.Create(h.Ctx, model, nil)
+	if err != nil {
+		rs.storeError(h, err)
+		return
+	}
+	afterLoad(h.Ctx, key, model)
+	rs.setValidators(h, model)
+	h.SetHeader("Location", strings.TrimSuffix(rs.Path, "/")+"/"+EncodeID(key))
+	h.ToJSONWithStatus(model, http.StatusCreated)
+}
+
+// PUT /:path/:key replaces the model, while PATCH only updates the fields
+// within the request. The preconditions are checked against the model saved
+// within the update's transaction.
+func (rs Resource) update(h *Handler, key *datastore.Key, patch bool) {
+	body, ok := rs.readBody(h)
+	if !ok {
+		return
+	}
+
+	model := rs.newModel()
+	err := rs.Store.UpdateFunc(h.Ctx, key, model, func() error {
+		rs.setValidators(h, model)
+		if h.preconditionStatus() != 0 {
+			return errPreconditionFailed
+		}
+		if !patch {
+			// the body is decoded over a new model, rather than the saved one,
+			// other than its creation time
+			var created time.Time
+			if t, ok := model.(timestamper); ok {
+				created = t.timestamps().CreatedAt
+			}
+			reflect.ValueOf(model).Elem().Set(reflect.Zero(rs.modelType()))
+			if t, ok := model.(timestamper); ok {
+				t.timestamps().CreatedAt = created
+			}
+		}
+		if v, ok := model.(Versioner); ok {
+			v.SetModelVersion(0)
+		}
+		if err := json.Unmarshal(body, model); err != nil {
+			return BadRequest(fmt.Sprintf("invalid JSON: %v", err))
+		}
+		return nil
+	})
+	if err != nil {
+		rs.storeError(h, err)
+		return
+	}
+	afterLoad(h.Ctx, key, model)
+	rs.setValidators(h, model)
+	h.ToJSON(model)
+}
+
+// DELETE /:path/:key checks the preconditions against the model saved within
+// the delete's transaction
+func (rs Resource) delete(h *Handler, key *datastore.Key) {
+	model := rs.newModel()
+	err := rs.Store.DeleteFunc(h.Ctx, key, model, func() error {
+		rs.setValidators(h, model)
+		if h.preconditionStatus() != 0 {
+			return errPreconditionFailed
+		}
+		return nil
+	})
+	if err != nil {
+		rs.storeError(h, err)
+		return
+	}
+	h.Res.Header().Del("ETag")
+	h.Res.Header().Del("Last-Modified")
+	h.SendStatus(http.StatusNoContent)
+}
+
+// readBody reads the request body, responding with a 413 status and returning
+// false if it is larger than the max size
+func (rs Resource) readBody(h *Handler) ([]byte, bool) {
+	if h.Req.Body == nil {
+		return nil, true
+	}
+	body, err := ioutil.ReadAll(h.body())
+	if err != nil {
+		rs.error(h, http.StatusBadRequest, err)
+		return nil, false
+	}
+	if int64(len(body)) > h.maxBodyBytes() {
+		rs.error(h, http.StatusRequestEntityTooLarge, errBodyTooLarge)
+		return nil, false
+	}
+	return body, true
+}
+
+// setValidators sets the ETag and Last-Modified headers of the model
+func (rs Resource) setValidators(h *Handler, model interface{}) {
+	if etag := EntityETag(model); etag != "" {
+		h.SetETag(etag)
+	}
+	if t := entityModTime(model); !t.IsZero() {
+		h.SetLastModified(t)
+	}
+}
+
+// checkPreconditions responds with a 304 status, or a 412 status in JSON,
+// returning false if the request's conditional headers aren't met
+func (rs Resource) checkPreconditions(h *Handler) bool {
+	if h.preconditionStatus() == http.StatusPreconditionFailed {
+		rs.error(h, http.StatusPreconditionFailed, nil)
+		return false
+	}
+	return h.CheckPreconditions()
+}
+
+// storeError maps the store's errors to their response status, with the
+// Store's other errors mapped by newProblem
+func (rs Resource) storeError(h *Handler, err error) {
+	switch err {
+	case ErrInvalidCursor:
+		rs.error(h, http.StatusBadRequest, err)
+	case errPreconditionFailed:
+		rs.error(h, http.StatusPreconditionFailed, nil)
+	default:
+		rs.error(h, http.StatusInternalServerError, err)
+	}
+}
+
+// error logs the error and responds with its problem details, which are always
+// JSON as the resource's responses are
+func (rs Resource) error(h *Handler, status int, err error) {
+	h.writeProblem(h.problem(status, err))
+}
@@ -0,0 +1,231 @@
+package ae
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+type resourcePost struct {
+	Model
+	Title  string `json:"title"`
+	Author string `json:"author"`
+	Views  int64  `json:"views"`
+}
+
+func (p *resourcePost) Valid() error {
+	if p.Title == "" {
+		return errors.New("title is required")
+	}
+	return nil
+}
+
+func TestResource(t *testing.T) {
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {}
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &resourcePost{}}
+	rs := Resource{
+		Path:         "/posts",
+		Store:        s,
+		Filters:      []string{"Author", "Views"},
+		MaxBodyBytes: 100,
+		Authorize: func(c context.Context, r *http.Request, action string, key *datastore.Key) bool {
+			return r.Header.Get("Authorization") != "" || action == ResourceList || action == ResourceShow
+		},
+	}
+
+	key, _ := s.Create(c, &resourcePost{Title: "foo", Author: "jim"}, nil)
+	s.Create(c, &resourcePost{Title: "bar", Author: "bob", Views: 3}, nil)
+	missing := datastore.NewKey(c, "posts", "", 999, nil).Encode()
+	other := datastore.NewKey(c, "users", "", key.IntID(), nil).Encode()
+
+	type test struct {
+		method string
+		path   string
+		body   string
+		auth   bool
+		status int
+		title  string
+	}
+
+	tests := []test{
+		test{method: "GET", path: "/posts/" + EncodeID(key), status: 200, title: "foo"},
+		test{method: "GET", path: "/posts/" + key.Encode(), status: 200, title: "foo"},
+		test{method: "GET", path: "/posts/" + missing, status: 404},
+		test{method: "GET", path: "/posts/" + other, status: 404},
+		test{method: "GET", path: "/posts/bad", status: 404},
+		test{method: "POST", path: "/posts", body: `{"title": "baz"}`, status: 403},
+		test{method: "POST", path: "/posts", body: `{"title": "baz"}`, auth: true, status: 201, title: "baz"},
+		test{method: "POST", path: "/posts", body: `{"author": "jim"}`, auth: true, status: 422},
+		test{method: "POST", path: "/posts", body: `{`, auth: true, status: 400},
+		test{method: "PATCH", path: "/posts/" + key.Encode(), body: `{"views": 5}`, auth: true, status: 200, title: "foo"},
+		test{method: "PATCH", path: "/posts/" + key.Encode(), body: `{"version": 1, "title": "old"}`, auth: true, status: 409},
+		test{method: "PUT", path: "/posts/" + key.Encode(), body: `{"views": 5}`, auth: true, status: 422},
+		test{method: "PUT", path: "/posts/" + key.Encode(), body: `{"title": "qux"}`, auth: true, status: 200, title: "qux"},
+		test{method: "PUT", path: "/posts/" + missing, body: `{"title": "qux"}`, auth: true, status: 404},
+		test{method: "PUT", path: "/posts/" + key.Encode(), body: `{"title": "` + strings.Repeat("a", 100) + `"}`, auth: true, status: 413},
+		test{method: "DELETE", path: "/posts/" + missing, auth: true, status: 404},
+		test{method: "DELETE", path: "/posts/" + key.Encode(), auth: true, status: 204},
+		test{method: "GET", path: "/posts/" + key.Encode(), status: 404},
+	}
+
+	for i, test := range tests {
+		r, _ := http.NewRequest(test.method, test.path, strings.NewReader(test.body))
+		if test.auth {
+			r.Header.Set("Authorization", "token")
+		}
+		w := httptest.NewRecorder()
+		rs.ServeHTTP(c, w, r)
+		if w.Code != test.status {
+			t.Errorf("%d. %s %s: status %d, expected %d: %s", i, test.method, test.path, w.Code, test.status, w.Body)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); test.status >= 400 && ct != "application/problem+json" {
+			t.Errorf("%d. %s %s: content type %s, expected problem details", i, test.method, test.path, ct)
+		}
+		if test.title == "" {
+			continue
+		}
+		var p resourcePost
+		json.NewDecoder(w.Body).Decode(&p)
+		if p.Title != test.title || p.ID == "" {
+			t.Errorf("%d. %s %s: invalid model %+v", i, test.method, test.path, p)
+		}
+	}
+}
+
+func TestResourceList(t *testing.T) {
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {}
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &resourcePost{}}
+	rs := Resource{Path: "/posts", Store: s, Filters: []string{"Author", "Views"}, Order: "Title", PageSize: 2}
+
+	for _, title := range []string{"a", "b", "c"} {
+		s.Create(c, &resourcePost{Title: title, Author: "jim", Views: 1}, nil)
+	}
+	s.Create(c, &resourcePost{Title: "d", Author: "bob"}, nil)
+
+	type test struct {
+		query  string
+		status int
+		titles string
+	}
+
+	tests := []test{
+		test{query: "", status: 200, titles: "a,b"},
+		test{query: "?limit=1", status: 200, titles: "a"},
+		test{query: "?limit=50", status: 200, titles: "a,b"},
+		test{query: "?Author=bob", status: 200, titles: "d"},
+		test{query: "?Views=1&limit=5", status: 200, titles: "a,b"},
+		test{query: "?Views=x", status: 400},
+		test{query: "?cursor=bad", status: 400},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/posts"+test.query, nil)
+		w := httptest.NewRecorder()
+		rs.ServeHTTP(c, w, r)
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d: %s", test.query, w.Code, test.status, w.Body)
+			continue
+		}
+		if test.titles == "" {
+			continue
+		}
+		var list struct {
+			Items  []resourcePost `json:"items"`
+			Cursor string         `json:"cursor"`
+		}
+		json.NewDecoder(w.Body).Decode(&list)
+		var titles []string
+		for _, p := range list.Items {
+			titles = append(titles, p.Title)
+		}
+		if strings.Join(titles, ",") != test.titles {
+			t.Errorf("%s: listed %v, expected %s", test.query, titles, test.titles)
+		}
+	}
+
+	// the cursor fetches the next page
+	r, _ := http.NewRequest("GET", "/posts", nil)
+	w := httptest.NewRecorder()
+	rs.ServeHTTP(c, w, r)
+	var first resourceList
+	json.NewDecoder(w.Body).Decode(&first)
+	r, _ = http.NewRequest("GET", "/posts?cursor="+first.Cursor, nil)
+	w = httptest.NewRecorder()
+	rs.ServeHTTP(c, w, r)
+	var next struct {
+		Items []resourcePost `json:"items"`
+	}
+	json.NewDecoder(w.Body).Decode(&next)
+	if len(next.Items) != 2 || next.Items[0].Title != "c" {
+		t.Errorf("invalid next page: %+v", next.Items)
+	}
+}
+
+func TestResourceConditional(t *testing.T) {
+	defer func(fn func(*Handler, int, error)) { logHandlerError = fn }(logHandlerError)
+	logHandlerError = func(h *Handler, status int, err error) {}
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &resourcePost{}}
+	rs := Resource{Path: "/posts", Store: s}
+
+	p := &resourcePost{Title: "foo"}
+	key, _ := s.Create(c, p, nil)
+	path := "/posts/" + EncodeID(key)
+
+	// the etag of the created version, while current is that of the last response
+	stale := EntityETag(p)
+
+	type test struct {
+		method  string
+		header  string
+		current bool
+		body    string
+		status  int
+	}
+
+	tests := []test{
+		test{method: "GET", status: 200},
+		test{method: "GET", header: "If-None-Match", current: true, status: 304},
+		test{method: "PATCH", header: "If-Match", current: true, body: `{"views": 1}`, status: 200},
+		test{method: "GET", header: "If-None-Match", status: 200},
+		test{method: "PATCH", header: "If-Match", body: `{"views": 2}`, status: 412},
+		test{method: "PUT", header: "If-Match", body: `{"title": "bar"}`, status: 412},
+		test{method: "PUT", header: "If-Match", current: true, body: `{"title": "bar"}`, status: 200},
+		test{method: "DELETE", header: "If-Match", status: 412},
+		test{method: "DELETE", header: "If-Match", current: true, status: 204},
+	}
+
+	var current string
+	for i, test := range tests {
+		r, _ := http.NewRequest(test.method, path, strings.NewReader(test.body))
+		if test.header != "" {
+			etag := stale
+			if test.current {
+				etag = current
+			}
+			r.Header.Set(test.header, etag)
+		}
+		w := httptest.NewRecorder()
+		rs.ServeHTTP(c, w, r)
+		if w.Code != test.status {
+			t.Errorf("%d. %s %s: status %d, expected %d: %s", i, test.method, test.header, w.Code, test.status, w.Body)
+			continue
+		}
+		if w.Code == 200 {
+			if current = w.Header().Get("ETag"); current == "" {
+				t.Errorf("%d. %s: missing etag", i, test.method)
+			}
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"strings"
 
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 )
 
@@ -105,9 +106,9 @@ func (r *Route) Contains(val string) bool {
 	return strings.Contains(r.req.URL.Path, val)
 }
 
-// Key wraps the public Key() method
+// Key decodes the named param's public ID, returning nil if it is invalid
 func (r *Route) Key(name string) *datastore.Key {
-	key, _ := datastore.DecodeKey(r.params[name])
+	key, _ := DecodeID(appengine.NewContext(r.req), r.params[name])
 	return key
 }
 
@@ -0,0 +1,247 @@
+package search
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/chrisolsen/ae"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const (
+	docTable = "searchDocs"
+
+	// the most documents loaded for each AND group of a query, which are all
+	// required to order the results by relevance
+	maxMatches = 1000
+)
+
+// document contains the terms of an indexed model, which is saved as a child
+// of the model's key
+type document struct {
+	Index    string
+	Terms    []string
+	Counts   []int64 `datastore:",noindex"`
+	Prefixes []string
+}
+
+// Index indexes the string fields of models, and implements ae.Indexer to be
+// kept in sync by an ae.Store.
+//  var posts = search.NewIndex("posts", "Title", "Body", "Tags")
+//  var store = ae.Store{TableName: "posts", Indexers: []ae.Indexer{posts}}
+//
+//  page, err := posts.Search(c, "red shoe* OR boots", r.FormValue("cursor"), 20)
+type Index struct {
+	Name string
+
+	// names of the string, or string slice, fields that are indexed
+	Fields []string
+}
+
+// NewIndex creates an index of the fields
+func NewIndex(name string, fields ...string) *Index {
+	return &Index{Name: name, Fields: fields}
+}
+
+func (x *Index) docKey(c context.Context, key *datastore.Key) *datastore.Key {
+	return datastore.NewKey(c, docTable, x.Name, 0, key)
+}
+
+// Index saves the terms of the model's fields
+func (x *Index) Index(c context.Context, key *datastore.Key, model interface{}) error {
+	text, err := x.text(model)
+	if err != nil {
+		return err
+	}
+
+	doc := document{Index: x.Name}
+	counts := make(map[string]int)
+	seen := make(map[string]bool)
+	for _, word := range tokenize(text) {
+		term := stem(word)
+		if counts[term] == 0 {
+			doc.Terms = append(doc.Terms, term)
+		}
+		counts[term]++
+		for _, p := range prefixes(word) {
+			if !seen[p] {
+				seen[p] = true
+				doc.Prefixes = append(doc.Prefixes, p)
+			}
+		}
+	}
+	for _, term := range doc.Terms {
+		doc.Counts = append(doc.Counts, int64(counts[term]))
+	}
+
+	if len(doc.Terms) == 0 {
+		return x.Unindex(c, key)
+	}
+	_, err = ae.BackendFromContext(c).Put(c, x.docKey(c, key), &doc)
+	return err
+}
+
+// Unindex deletes the terms of the model
+func (x *Index) Unindex(c context.Context, key *datastore.Key) error {
+	return ae.BackendFromContext(c).Delete(c, x.docKey(c, key))
+}
+
+// text joins the values of the indexed fields
+func (x *Index) text(model interface{}) (string, error) {
+	v := reflect.Indirect(reflect.ValueOf(model))
+	if v.Kind() != reflect.Struct {
+		return "", fmt.Errorf("search: %T is not a struct", model)
+	}
+	var values []string
+	for _, name := range x.Fields {
+		f := v.FieldByName(name)
+		switch {
+		case f.Kind() == reflect.String:
+			values = append(values, f.String())
+		case f.Kind() == reflect.Slice && f.Type().Elem().Kind() == reflect.String:
+			for i := 0; i < f.Len(); i++ {
+				values = append(values, f.Index(i).String())
+			}
+		default:
+			return "", fmt.Errorf("search: %s of %T is not a string field", name, model)
+		}
+	}
+	return strings.Join(values, " "), nil
+}
+
+type queryTerm struct {
+	value  string
+	prefix bool
+}
+
+// parseQuery splits the query into groups of terms separated by OR, with the
+// terms within each group being ANDed together. Words ending in `*` match any
+// terms they are a prefix of.
+func parseQuery(query string) [][]queryTerm {
+	var groups [][]queryTerm
+	var group []queryTerm
+	for _, word := range strings.Fields(query) {
+		if word == "OR" {
+			if len(group) > 0 {
+				groups = append(groups, group)
+			}
+			group = nil
+			continue
+		}
+		prefix := strings.HasSuffix(word, "*")
+		tokens := tokenize(word)
+		for i, token := range tokens {
+			// only the last token of `e-mail*` is a prefix
+			if prefix && i == len(tokens)-1 {
+				runes := []rune(token)
+				if len(runes) < minPrefixLength {
+					continue
+				}
+				if len(runes) > maxPrefixLength {
+					token = string(runes[:maxPrefixLength])
+				}
+				group = append(group, queryTerm{value: token, prefix: true})
+				continue
+			}
+			group = append(group, queryTerm{value: stem(token)})
+		}
+	}
+	if len(group) > 0 {
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+type match struct {
+	key   *datastore.Key
+	score float64
+}
+
+// byScore orders the matches by descending score, and then by key
+type byScore []*match
+
+func (m byScore) Len() int      { return len(m) }
+func (m byScore) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byScore) Less(i, j int) bool {
+	if m[i].score != m[j].score {
+		return m[i].score > m[j].score
+	}
+	return m[i].key.String() < m[j].key.String()
+}
+
+// Search returns a page of the keys of the models matching the query, ordered
+// by relevance. The page's cursor can be passed in to fetch the next page.
+func (x *Index) Search(c context.Context, query string, cursor string, limit int) (*ae.Page, error) {
+	var offset int
+	if cursor != "" {
+		var err error
+		if offset, err = strconv.Atoi(cursor); err != nil || offset < 0 {
+			return nil, ae.ErrInvalidCursor
+		}
+	}
+
+	b := ae.BackendFromContext(c)
+	matches := make(map[string]*match)
+	for _, group := range parseQuery(query) {
+		q := ae.NewQuery(docTable).Filter("Index =", x.Name).Limit(maxMatches)
+		for _, term := range group {
+			if term.prefix {
+				q = q.Filter("Prefixes =", term.value)
+			} else {
+				q = q.Filter("Terms =", term.value)
+			}
+		}
+
+		var docs []*document
+		keys, err := b.GetAll(c, q, &docs)
+		if err != nil {
+			return nil, err
+		}
+		for i, key := range keys {
+			modelKey := key.Parent()
+			m, ok := matches[modelKey.Encode()]
+			if !ok {
+				m = &match{key: modelKey}
+				matches[modelKey.Encode()] = m
+			}
+			m.score += score(docs[i], group)
+		}
+	}
+
+	sorted := make([]*match, 0, len(matches))
+	for _, m := range matches {
+		sorted = append(sorted, m)
+	}
+	sort.Sort(byScore(sorted))
+
+	page := &ae.Page{}
+	for i := offset; i < len(sorted) && (limit < 0 || i < offset+limit); i++ {
+		page.Keys = append(page.Keys, sorted[i].key)
+	}
+	if next := offset + len(page.Keys); limit > 0 && next < len(sorted) {
+		page.Cursor = strconv.Itoa(next)
+	}
+	return page, nil
+}
+
+// score sums the number of times each of the terms occurs within the document,
+// with prefix matches only counting as half a term
+func score(doc *document, terms []queryTerm) float64 {
+	var total float64
+	for _, term := range terms {
+		if term.prefix {
+			total += 0.5
+			continue
+		}
+		for i, t := range doc.Terms {
+			if t == term.value && i < len(doc.Counts) {
+				total += float64(doc.Counts[i])
+			}
+		}
+	}
+	return total
+}
@@ -0,0 +1,109 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/chrisolsen/ae"
+	"google.golang.org/appengine/datastore"
+)
+
+func TestStem(t *testing.T) {
+	type test struct {
+		word string
+		stem string
+	}
+
+	tests := []test{
+		test{word: "running", stem: "run"},
+		test{word: "runs", stem: "run"},
+		test{word: "boxes", stem: "box"},
+		test{word: "shoes", stem: "shoe"},
+		test{word: "class", stem: "class"},
+		test{word: "stories", stem: "story"},
+		test{word: "is", stem: "is"},
+	}
+
+	for _, test := range tests {
+		if s := stem(test.word); s != test.stem {
+			t.Errorf("%s: expected %s, got %s", test.word, test.stem, s)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	tokens := tokenize("Crème Brûlée, the CAFÉ's best!")
+	expected := []string{"creme", "brulee", "the", "cafe", "s", "best"}
+	if len(tokens) != len(expected) {
+		t.Errorf("expected %v, got %v", expected, tokens)
+		return
+	}
+	for i := range tokens {
+		if tokens[i] != expected[i] {
+			t.Errorf("expected %v, got %v", expected, tokens)
+			return
+		}
+	}
+}
+
+type post struct {
+	ae.Model
+	Title string
+	Body  string
+	Tags  []string
+}
+
+func TestSearch(t *testing.T) {
+	c := ae.NewMemoryContext()
+	index := NewIndex("posts", "Title", "Body", "Tags")
+	s := ae.Store{TableName: "posts", Indexers: []ae.Indexer{index}}
+
+	red, _ := s.Create(c, &post{Title: "Red running shoes", Body: "Red shoes for running on red tracks"}, nil)
+	blue, _ := s.Create(c, &post{Title: "Blue shoes", Body: "Shoes that are blue", Tags: []string{"sale"}}, nil)
+	boots, _ := s.Create(c, &post{Title: "Hiking boots", Body: "Crème colored boots"}, nil)
+
+	type test struct {
+		query string
+		keys  []*datastore.Key
+	}
+
+	tests := []test{
+		test{query: "shoe", keys: []*datastore.Key{red, blue}},
+		test{query: "RED run", keys: []*datastore.Key{red}},
+		test{query: "red blue", keys: nil},
+		test{query: "red OR boots", keys: []*datastore.Key{red, boots}},
+		test{query: "hik*", keys: []*datastore.Key{boots}},
+		test{query: "creme", keys: []*datastore.Key{boots}},
+		test{query: "sale", keys: []*datastore.Key{blue}},
+	}
+
+	for _, test := range tests {
+		page, err := index.Search(c, test.query, "", 10)
+		if err != nil {
+			t.Errorf("%s: %v", test.query, err)
+			continue
+		}
+		if len(page.Keys) != len(test.keys) {
+			t.Errorf("%s: expected %d results, got %d", test.query, len(test.keys), len(page.Keys))
+			continue
+		}
+		for i, key := range page.Keys {
+			if !key.Equal(test.keys[i]) {
+				t.Errorf("%s: expected %v at %d, got %v", test.query, test.keys[i], i, key)
+			}
+		}
+	}
+
+	// pagination
+	page, _ := index.Search(c, "shoes", "", 1)
+	next, _ := index.Search(c, "shoes", page.Cursor, 1)
+	if len(page.Keys) != 1 || !page.Keys[0].Equal(red) || len(next.Keys) != 1 || !next.Keys[0].Equal(blue) || next.Cursor != "" {
+		t.Errorf("invalid pages: %+v %+v", page, next)
+	}
+
+	// kept in sync with updates and deletes
+	s.Update(c, blue, &post{Title: "Green hat"})
+	s.Delete(c, red)
+	if page, _ = index.Search(c, "shoes", "", 10); len(page.Keys) != 0 {
+		t.Errorf("index not updated: %v", page.Keys)
+	}
+}
@@ -0,0 +1,115 @@
+package search
+
+import (
+	"strings"
+	"unicode"
+)
+
+const (
+	// shorter prefixes match too many terms to be useful
+	minPrefixLength = 2
+
+	// longer terms are only matched by prefixes up to this length
+	maxPrefixLength = 15
+)
+
+// accents maps the accented latin characters to their unaccented equivalent
+var accents = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ă': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ė': "e", 'ę': "e", 'ě': "e",
+	'ğ': "g", 'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'į': "i", 'ı': "i",
+	'ł': "l", 'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ş': "s", 'ß': "ss", 'ť': "t", 'ţ': "t",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u", 'ų': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
+
+// fold lower cases the text and removes the accents
+func fold(s string) string {
+	s = strings.ToLower(s)
+	var folded []rune
+	for _, r := range s {
+		if a, ok := accents[r]; ok {
+			folded = append(folded, []rune(a)...)
+			continue
+		}
+		folded = append(folded, r)
+	}
+	return string(folded)
+}
+
+// tokenize splits the folded text into words on any non letter or digit
+func tokenize(s string) []string {
+	return strings.FieldsFunc(fold(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// suffixes removed by the stemmer, longest first, with their replacements
+var suffixes = []struct {
+	suffix      string
+	replacement string
+}{
+	{"ational", "ate"},
+	{"fulness", "ful"},
+	{"iveness", "ive"},
+	{"ization", "ize"},
+	{"ousness", "ous"},
+	{"ation", "ate"},
+	{"ness", ""},
+	{"ings", ""},
+	{"edly", ""},
+	{"ies", "y"},
+	{"ing", ""},
+	{"es", ""},
+	{"ed", ""},
+	{"ly", ""},
+	{"s", ""},
+}
+
+// stem reduces the English word to a stem by removing its common suffixes,
+// so that words such as run, runs and running match. Stems shorter than three
+// characters are not reduced any further.
+func stem(word string) string {
+	for _, s := range suffixes {
+		if !strings.HasSuffix(word, s.suffix) {
+			continue
+		}
+		// boxes => box, but shoes => shoe
+		if s.suffix == "es" && !hasAnySuffix(word[:len(word)-2], "s", "x", "z", "ch", "sh") {
+			continue
+		}
+		stemmed := word[:len(word)-len(s.suffix)] + s.replacement
+		if len(stemmed) < 3 || strings.HasSuffix(word, "ss") {
+			return word
+		}
+		// running => runn => run
+		if n := len(stemmed); s.replacement == "" && n > 3 && stemmed[n-1] == stemmed[n-2] && !strings.ContainsRune("lsz", rune(stemmed[n-1])) {
+			stemmed = stemmed[:n-1]
+		}
+		return stemmed
+	}
+	return word
+}
+
+func hasAnySuffix(s string, suffixes ...string) bool {
+	for _, suffix := range suffixes {
+		if strings.HasSuffix(s, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// prefixes returns the prefixes of the word, including the word itself if it
+// is short enough, used for prefix matching
+func prefixes(word string) []string {
+	runes := []rune(word)
+	var p []string
+	for i := minPrefixLength; i <= len(runes) && i <= maxPrefixLength; i++ {
+		p = append(p, string(runes[:i]))
+	}
+	return p
+}
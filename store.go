@@ -1,16 +1,44 @@
 package ae
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"time"
 
 	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/memcache"
 )
 
+var (
+	errInvalidMultiArg = errors.New("dst must be a slice the same length as the keys")
+	errNotSoftDeleted  = errors.New("the store's model must embed SoftDelete")
+)
+
 // Store is the include common attrs and methods for other *model types
 type Store struct {
 	TableName string
+
+	// Backend overrides the backend within the context, or the default
+	// App Engine backend if neither is set
+	Backend Backend
+
+	// Model is an optional pointer to a zero value of the store's model, which
+	// allows the store to load models on its own, such as for BeforeDelete. It
+	// is required for models that embed SoftDelete to be soft deleted.
+	//  s := ae.Store{TableName: "posts", Model: &Post{}}
+	Model interface{}
+
+	// Audit results in a History child entity being saved on each create,
+	// update and delete, which can be listed with History and reverted to with
+	// Revert.
+	Audit bool
+
+	// Indexers are kept in sync with the models saved and deleted through
+	// the store
+	Indexers []Indexer
 }
 
 // NewStore is a helper to create a base store
@@ -18,46 +46,859 @@ func NewStore(tableName string) Store {
 	return Store{TableName: tableName}
 }
 
-// Delete deletes the record and clears the memcached record
+func (s Store) backend(c context.Context) Backend {
+	if s.Backend != nil {
+		return s.Backend
+	}
+	return BackendFromContext(c)
+}
+
+// newModel returns a pointer to a new zero value of the store's model, or nil
+// if the store's model isn't set
+func (s Store) newModel() interface{} {
+	if s.Model == nil {
+		return nil
+	}
+	return reflect.New(reflect.TypeOf(s.Model).Elem()).Interface()
+}
+
+// beforeDelete loads the model and calls its BeforeDelete hook
+func (s Store) beforeDelete(c context.Context, key *datastore.Key) error {
+	model := s.newModel()
+	if _, ok := model.(BeforeDeleter); !ok {
+		return nil
+	}
+	err := s.backend(c).Get(c, key, model)
+	if err == datastore.ErrNoSuchEntity {
+		return nil
+	}
+	if err != nil && !isFieldMismatch(err) {
+		return err
+	}
+	if err = afterLoad(c, key, model); err != nil {
+		return err
+	}
+	return model.(BeforeDeleter).BeforeDelete(c)
+}
+
+// Delete deletes the record and clears the memcached record. If the store's
+// model embeds SoftDelete, the record is only marked as deleted.
 func (s Store) Delete(c context.Context, key *datastore.Key) error {
-	err := datastore.Delete(c, key)
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	if model := s.newModel(); isSoftDeleter(model) {
+		return s.softDelete(c, key, model)
+	}
+	if err := s.beforeDelete(c, key); err != nil {
+		return err
+	}
+	old, err := s.savedProperties(c, key)
+	if err != nil {
+		return err
+	}
+	prev := s.loadPrevious(c, key, reflect.TypeOf(s.Model))
+	if err = s.deleteEntity(c, key); err != nil {
+		return err
+	}
+	s.clearCache(c, key)
+	s.unindex(c, key)
+	if old != nil {
+		if err = s.record(c, key, HistoryDelete, old, nil); err != nil {
+			return err
+		}
+	}
+	s.publish(c, EventDeleted, key, prev, nil)
+	return nil
+}
+
+// DeleteFunc loads the model into dst and calls check, deleting the model within
+// the same transaction if check doesn't return an error, which is returned
+// instead. The model is deleted as it is by Delete.
+//  err := s.DeleteFunc(c, key, &post, func() error {
+//  	if post.Version != input.Version {
+//  		return errStale
+//  	}
+//  	return nil
+//  })
+func (s Store) DeleteFunc(c context.Context, key *datastore.Key, dst interface{}, check func() error) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	b := s.backend(c)
+	var txOptions *datastore.TransactionOptions
+	if isUniquer(dst) {
+		txOptions = uniqueTxOptions
+	}
+	return runInTransaction(b, c, func(tc context.Context) error {
+		if err := b.Get(tc, key, dst); err != nil && !isFieldMismatch(err) {
+			return err
+		}
+		if isDeleted(dst) {
+			return datastore.ErrNoSuchEntity
+		}
+		if err := afterLoad(tc, key, dst); err != nil {
+			return err
+		}
+		if err := check(); err != nil {
+			return err
+		}
+		return s.Delete(tc, key)
+	}, txOptions)
+}
+
+// softDelete marks the model as deleted within a transaction
+func (s Store) softDelete(c context.Context, key *datastore.Key, model interface{}) error {
+	b := s.backend(c)
+	var prev interface{}
+	err := runInTransaction(b, c, func(tc context.Context) error {
+		prev = nil
+		err := b.Get(tc, key, model)
+		if err == datastore.ErrNoSuchEntity || isDeleted(model) {
+			return nil
+		}
+		if err != nil && !isFieldMismatch(err) {
+			return err
+		}
+		if err = afterLoad(tc, key, model); err != nil {
+			return err
+		}
+		if d, ok := model.(BeforeDeleter); ok {
+			if err := d.BeforeDelete(tc); err != nil {
+				return err
+			}
+		}
+		old, err := s.modelProperties(model)
+		if err != nil {
+			return err
+		}
+		prev = copyModel(model)
+		sd := model.(softDeleter).softDelete()
+		sd.Deleted, sd.DeletedAt = true, timeNow()
+		if _, err = b.Put(tc, key, model); err != nil {
+			return err
+		}
+		return s.recordTx(tc, key, HistoryDelete, old, model)
+	}, nil)
+	if err != nil || prev == nil {
+		return err
+	}
+	s.clearCache(c, key)
+	s.unindex(c, key)
+	s.publish(c, EventDeleted, key, prev, nil)
+	return nil
+}
+
+// Restore undoes the soft delete of the record
+func (s Store) Restore(c context.Context, key *datastore.Key) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	model := s.newModel()
+	if !isSoftDeleter(model) {
+		return errNotSoftDeleted
+	}
+	b := s.backend(c)
+	var prev interface{}
+	err := runInTransaction(b, c, func(tc context.Context) error {
+		prev = nil
+		if err := b.Get(tc, key, model); err != nil && !isFieldMismatch(err) {
+			return err
+		}
+		sd := model.(softDeleter).softDelete()
+		if !sd.Deleted {
+			return nil
+		}
+		old, err := s.modelProperties(model)
+		if err != nil {
+			return err
+		}
+		prev = copyModel(model)
+		sd.Deleted, sd.DeletedAt = false, time.Time{}
+		if _, err = b.Put(tc, key, model); err != nil {
+			return err
+		}
+		return s.recordTx(tc, key, HistoryRestore, old, model)
+	}, nil)
+	if err != nil || prev == nil {
+		return err
+	}
+	s.clearCache(c, key)
+	s.index(c, key, model)
+	s.publish(c, EventUpdated, key, prev, model)
+	return nil
+}
+
+// Purge permanently deletes the record, whether or not it has been soft
+// deleted, and clears the memcached record
+func (s Store) Purge(c context.Context, key *datastore.Key) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	old, err := s.savedProperties(c, key)
 	if err != nil {
 		return err
 	}
-	memcache.Delete(c, key.Encode())
+	prev := s.loadPrevious(c, key, reflect.TypeOf(s.Model))
+	if err = s.deleteEntity(c, key); err != nil {
+		return err
+	}
+	s.clearCache(c, key)
+	s.unindex(c, key)
+	if old != nil {
+		if err = s.record(c, key, HistoryDelete, old, nil); err != nil {
+			return err
+		}
+	}
+	s.publish(c, EventDeleted, key, prev, nil)
 	return nil
 }
 
 // Create creates the model
 func (s Store) Create(c context.Context, data interface{}, parentKey *datastore.Key) (*datastore.Key, error) {
+	if err := checkTenant(c, parentKey); err != nil {
+		return nil, err
+	}
+	if err := beforeSave(c, data, true); err != nil {
+		return nil, err
+	}
+	if v, ok := data.(Versioner); ok && v.ModelVersion() == 0 {
+		v.SetModelVersion(1)
+	}
 	key := datastore.NewIncompleteKey(c, s.TableName, parentKey)
-	return datastore.Put(c, key, data)
+	var err error
+	if isUniquer(data) {
+		key, err = s.putUnique(c, key, data)
+	} else {
+		key, err = s.backend(c).Put(c, key, data)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = s.record(c, key, HistoryCreate, nil, data); err != nil {
+		return key, err
+	}
+	s.index(c, key, data)
+	s.publish(c, EventCreated, key, nil, data)
+	return key, nil
 }
 
-// Update updates the model and clears the memcached data
+// Update updates the model and clears the memcached data. Versioned models are
+// updated within a transaction that checks the model's version against the
+// saved version, returning an ErrConflict if it is out of date. The transaction
+// joins that of RunInTransaction, while updates within transactions started by
+// datastore.RunInTransaction fail as nested transactions aren't supported.
+// The saved creation time is kept, and soft deleted models result in a
+// datastore.ErrNoSuchEntity.
 func (s Store) Update(c context.Context, key *datastore.Key, data interface{}) error {
-	_, err := datastore.Put(c, key, data)
-	memcache.Delete(c, key.Encode())
-	return err
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	if err := beforeSave(c, data, false); err != nil {
+		return err
+	}
+	b := s.backend(c)
+	v, versioned := data.(Versioner)
+	if !versioned && !isUniquer(data) {
+		old, err := s.savedProperties(c, key)
+		if err != nil {
+			return err
+		}
+		prev, err := loadSaved(c, b, key, data)
+		if err != nil {
+			return err
+		}
+		if _, err = b.Put(c, key, data); err != nil {
+			return err
+		}
+		s.clearCache(c, key)
+		if err = s.record(c, key, HistoryUpdate, old, data); err != nil {
+			return err
+		}
+		s.index(c, key, data)
+		s.publish(c, EventUpdated, key, prev, data)
+		return nil
+	}
+
+	var version int64
+	if versioned {
+		version = v.ModelVersion()
+	}
+	var txOptions *datastore.TransactionOptions
+	if isUniquer(data) {
+		txOptions = uniqueTxOptions
+	}
+	var prev interface{}
+	err := runInTransaction(b, c, func(tc context.Context) error {
+		current, err := loadSaved(tc, b, key, data)
+		if err != nil {
+			return err
+		}
+		prev = current
+		var currentVersion int64
+		var old []datastore.Property
+		var oldValues map[string]string
+		if current != nil {
+			if cv, ok := current.(Versioner); ok {
+				currentVersion = cv.ModelVersion()
+			}
+			if old, err = s.modelProperties(current); err != nil {
+				return err
+			}
+			oldValues = uniqueValues(current)
+		}
+		if versioned {
+			if version != 0 && version != currentVersion {
+				return ErrConflict{Key: key, Version: version, CurrentVersion: currentVersion}
+			}
+			v.SetModelVersion(currentVersion + 1)
+		}
+		if _, err = b.Put(tc, key, data); err != nil {
+			return err
+		}
+		if err = s.updateUnique(tc, key, oldValues, uniqueValues(data)); err != nil {
+			return err
+		}
+		return s.recordTx(tc, key, HistoryUpdate, old, data)
+	}, txOptions)
+	if err != nil {
+		if versioned {
+			v.SetModelVersion(version)
+		}
+		return err
+	}
+	s.clearCache(c, key)
+	s.index(c, key, data)
+	s.publish(c, EventUpdated, key, prev, data)
+	return nil
+}
+
+// UpdateFunc loads the model into dst, calls mutate to modify it, and saves it
+// within a transaction that is retried on contention. If mutate sets dst's
+// version, to the version the client last read, the update is rejected with an
+// ErrConflict when it doesn't match the saved version. The cached data is only
+// cleared once the transaction has been committed.
+//  err := s.UpdateFunc(c, key, &post, func() error {
+//  	post.Title = input.Title
+//  	post.Version = input.Version
+//  	return nil
+//  })
+func (s Store) UpdateFunc(c context.Context, key *datastore.Key, dst interface{}, mutate func() error) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	b := s.backend(c)
+	var txOptions *datastore.TransactionOptions
+	if isUniquer(dst) {
+		txOptions = uniqueTxOptions
+	}
+	var prev interface{}
+	err := runInTransaction(b, c, func(tc context.Context) error {
+		prev = s.loadPrevious(tc, key, reflect.TypeOf(dst))
+		if err := b.Get(tc, key, dst); err != nil && !isFieldMismatch(err) {
+			return err
+		}
+		if isDeleted(dst) {
+			return datastore.ErrNoSuchEntity
+		}
+		if err := afterLoad(tc, key, dst); err != nil {
+			return err
+		}
+		old, err := s.modelProperties(dst)
+		if err != nil {
+			return err
+		}
+		oldValues := uniqueValues(dst)
+		v, versioned := dst.(Versioner)
+		var version int64
+		if versioned {
+			version = v.ModelVersion()
+		}
+		if err := mutate(); err != nil {
+			return err
+		}
+		if err := beforeSave(tc, dst, false); err != nil {
+			return err
+		}
+		if versioned {
+			if v.ModelVersion() != 0 && v.ModelVersion() != version {
+				return ErrConflict{Key: key, Version: v.ModelVersion(), CurrentVersion: version}
+			}
+			v.SetModelVersion(version + 1)
+		}
+		if _, err = b.Put(tc, key, dst); err != nil {
+			return err
+		}
+		if err = s.updateUnique(tc, key, oldValues, uniqueValues(dst)); err != nil {
+			return err
+		}
+		return s.recordTx(tc, key, HistoryUpdate, old, dst)
+	}, txOptions)
+	if err != nil {
+		return err
+	}
+	s.clearCache(c, key)
+	s.index(c, key, dst)
+	s.publish(c, EventUpdated, key, prev, dst)
+	return nil
+}
+
+// loadSaved returns the saved entity that the model is replacing, or nil if
+// there isn't one, and carries its creation time over to the model. Soft
+// deleted entities result in a datastore.ErrNoSuchEntity.
+func loadSaved(c context.Context, b Backend, key *datastore.Key, data interface{}) (interface{}, error) {
+	current := reflect.New(reflect.TypeOf(data).Elem()).Interface()
+	err := b.Get(c, key, current)
+	if err == datastore.ErrNoSuchEntity {
+		return nil, nil
+	}
+	if err != nil && !isFieldMismatch(err) {
+		return nil, err
+	}
+	if isDeleted(current) {
+		return nil, datastore.ErrNoSuchEntity
+	}
+	if ct, ok := current.(timestamper); ok && !ct.timestamps().CreatedAt.IsZero() {
+		if t, ok := data.(timestamper); ok {
+			t.timestamps().CreatedAt = ct.timestamps().CreatedAt
+		}
+	}
+	return current, nil
+}
+
+func isFieldMismatch(err error) bool {
+	_, ok := err.(*datastore.ErrFieldMismatch)
+	return ok
 }
 
 // Get attempts to return the cached model, if no cached data exists, it then
-// fetches the data from the database and caches the data
+// fetches the data from the database and caches the data. Soft deleted models
+// result in a datastore.ErrNoSuchEntity.
 func (s Store) Get(c context.Context, key *datastore.Key, dst interface{}) (*datastore.Key, error) {
+	if err := checkTenant(c, key); err != nil {
+		return nil, err
+	}
+	b := s.backend(c)
 	encodedKey := key.Encode()
-	_, err := memcache.Gob.Get(c, encodedKey, dst)
+	err := b.CacheGet(c, encodedKey, dst)
 	if err == nil {
-		return key, nil
+		return key, afterLoad(c, key, dst)
 	}
 	if err != memcache.ErrCacheMiss {
 		return nil, fmt.Errorf("memcache get: %v", err)
 	}
 
-	err = datastore.Get(c, key, dst)
+	err = b.Get(c, key, dst)
 	if err != nil {
 		return nil, err
 	}
+	if isDeleted(dst) {
+		return nil, datastore.ErrNoSuchEntity
+	}
 
-	memcache.Gob.Set(c, &memcache.Item{Key: encodedKey, Object: dst})
-	return key, nil
+	b.CacheSet(c, encodedKey, dst, 0)
+	return key, afterLoad(c, key, dst)
+}
+
+// Query returns a new query against the store's table
+//  q := s.Query().Filter("Username =", name).Order("-Created").Limit(20)
+func (s Store) Query() *Query {
+	return NewQuery(s.TableName)
+}
+
+// scope excludes the soft deleted models from the query, unless the query
+// includes them
+func (s Store) scope(q *Query, dst interface{}) *Query {
+	if q.withDeleted || !s.softDeletes(dst) {
+		return q
+	}
+	return q.Filter("Deleted =", false)
+}
+
+// softDeletes indicates if the store's models, or the ones being loaded into
+// dst, are soft deleted
+func (s Store) softDeletes(dst interface{}) bool {
+	if s.Model != nil {
+		return isSoftDeleter(s.Model)
+	}
+	dv, err := sliceValue(dst)
+	if err != nil {
+		return false
+	}
+	t := dv.Type().Elem()
+	if t.Kind() != reflect.Ptr {
+		t = reflect.PtrTo(t)
+	}
+	return t.Implements(softDeleterType)
+}
+
+// GetAll returns the keys of all the models matching the query, loading the
+// models into dst, which must be a pointer to a slice. Soft deleted models are
+// excluded unless the query includes them with WithDeleted.
+func (s Store) GetAll(c context.Context, q *Query, dst interface{}) ([]*datastore.Key, error) {
+	if err := checkTenant(c, q.ancestor); err != nil {
+		return nil, err
+	}
+	q = s.scope(q, dst)
+	if q.keysOnly {
+		return s.backend(c).GetAll(c, q, dst)
+	}
+	if q.hydrate {
+		keys, err := s.backend(c).GetAll(c, q.KeysOnly(), nil)
+		if err != nil {
+			return nil, err
+		}
+		return keys, s.hydrate(c, keys, dst)
+	}
+
+	dv, err := sliceValue(dst)
+	if err != nil {
+		return nil, err
+	}
+	start := dv.Len()
+	keys, err := s.backend(c).GetAll(c, q, dst)
+	if err != nil && !isFieldMismatch(err) {
+		return nil, err
+	}
+	if hookErr := afterLoadSlice(c, keys, dv, start); hookErr != nil {
+		return nil, hookErr
+	}
+	return keys, err
+}
+
+// Page contains the keys of a page of query results
+type Page struct {
+	Keys []*datastore.Key `json:"keys"`
+
+	// Cursor is the URL safe cursor of the next page, that is empty once
+	// there are no more results
+	Cursor string `json:"cursor"`
+}
+
+// GetPage returns a page of models matching the query, which should have a
+// limit set. The returned page's cursor can be passed to the query's Start
+// method to fetch the following page.
+//  q := s.Query().Order("Name").Limit(20).Start(r.FormValue("cursor"))
+//  page, err := s.GetPage(c, q, &users)
+func (s Store) GetPage(c context.Context, q *Query, dst interface{}) (*Page, error) {
+	if err := checkTenant(c, q.ancestor); err != nil {
+		return nil, err
+	}
+	q = s.scope(q, dst)
+	hydrate := q.hydrate && !q.keysOnly
+	if hydrate {
+		q = q.KeysOnly()
+	}
+	var dv reflect.Value
+	var start int
+	if !q.keysOnly {
+		var err error
+		if dv, err = sliceValue(dst); err != nil {
+			return nil, err
+		}
+		start = dv.Len()
+	}
+
+	keys, cursor, err := s.backend(c).GetPage(c, q, dst)
+	if err != nil && !isFieldMismatch(err) {
+		return nil, err
+	}
+	if hydrate {
+		if err = s.hydrate(c, keys, dst); err != nil {
+			return nil, err
+		}
+	} else if !q.keysOnly {
+		if err = afterLoadSlice(c, keys, dv, start); err != nil {
+			return nil, err
+		}
+	}
+
+	page := &Page{Keys: keys}
+	if q.limit > 0 && len(keys) == q.limit {
+		page.Cursor = cursor
+	}
+	return page, nil
+}
+
+// hydrate fetches the models of the keys through the cache into dst
+func (s Store) hydrate(c context.Context, keys []*datastore.Key, dst interface{}) error {
+	dv, err := sliceValue(dst)
+	if err != nil {
+		return err
+	}
+	models := reflect.MakeSlice(dv.Type(), len(keys), len(keys))
+	if err = s.GetMulti(c, keys, models.Interface()); err != nil {
+		return err
+	}
+	dv.Set(reflect.AppendSlice(dv, models))
+	return nil
+}
+
+// GetMulti is a batch version of Get. dst must be a slice of structs or struct
+// pointers the same length as keys. The cached models are fetched in one call,
+// with only the misses being fetched from the datastore and then cached. Like
+// datastore.GetMulti, an appengine.MultiError is returned containing the
+// per-key errors.
+func (s Store) GetMulti(c context.Context, keys []*datastore.Key, dst interface{}) error {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Slice || dv.Len() != len(keys) {
+		return errInvalidMultiArg
+	}
+	if err := checkTenant(c, keys...); err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return nil
+	}
+	b := s.backend(c)
+	encodedKeys := encodeKeys(keys)
+
+	// an unavailable cache results in all models being fetched from the datastore
+	var misses []int
+	switch err := b.CacheGetMulti(c, encodedKeys, dst).(type) {
+	case nil:
+		return afterLoadSlice(c, keys, dv, 0)
+	case appengine.MultiError:
+		for i, e := range err {
+			if e != nil {
+				misses = append(misses, i)
+			}
+		}
+	default:
+		for i := range keys {
+			misses = append(misses, i)
+		}
+	}
+
+	missKeys := make([]*datastore.Key, len(misses))
+	missDst := reflect.MakeSlice(dv.Type(), len(misses), len(misses))
+	for j, i := range misses {
+		missKeys[j] = keys[i]
+		elemPointer(dv.Index(i)) // allocates nil struct pointers
+		missDst.Index(j).Set(dv.Index(i))
+	}
+
+	var getErrs appengine.MultiError
+	if err := b.GetMulti(c, missKeys, missDst.Interface()); err != nil {
+		me, ok := err.(appengine.MultiError)
+		if !ok {
+			return err
+		}
+		getErrs = me
+	}
+
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	var cacheKeys []string
+	cacheVals := reflect.MakeSlice(dv.Type(), 0, len(misses))
+	for j, i := range misses {
+		dv.Index(i).Set(missDst.Index(j))
+		if getErrs != nil && getErrs[j] != nil {
+			errs[i], hasErr = getErrs[j], true
+			continue
+		}
+		if isDeleted(elemPointer(dv.Index(i))) {
+			errs[i], hasErr = datastore.ErrNoSuchEntity, true
+			continue
+		}
+		cacheKeys = append(cacheKeys, encodedKeys[i])
+		cacheVals = reflect.Append(cacheVals, missDst.Index(j))
+	}
+	if len(cacheKeys) > 0 {
+		b.CacheSetMulti(c, cacheKeys, cacheVals.Interface(), 0)
+	}
+
+	for i, key := range keys {
+		if errs[i] != nil {
+			continue
+		}
+		if err := afterLoad(c, key, elemPointer(dv.Index(i))); err != nil {
+			errs[i], hasErr = err, true
+		}
+	}
+
+	if hasErr {
+		return errs
+	}
+	return nil
+}
+
+// PutMulti is a batch version of Create and Update that saves the models and
+// clears the cached data of each
+func (s Store) PutMulti(c context.Context, keys []*datastore.Key, src interface{}) ([]*datastore.Key, error) {
+	if err := checkTenant(c, keys...); err != nil {
+		return nil, err
+	}
+	sv := reflect.ValueOf(src)
+	if sv.Kind() != reflect.Slice || sv.Len() != len(keys) {
+		return nil, errInvalidMultiArg
+	}
+	errs := make(appengine.MultiError, len(keys))
+	var hasErr bool
+	olds := make([][]datastore.Property, len(keys))
+	prevs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		var err error
+		if olds[i], err = s.savedProperties(c, key); err != nil {
+			return nil, err
+		}
+		create := key == nil || key.Incomplete()
+		data := elemPointer(sv.Index(i))
+		prevs[i] = s.loadPrevious(c, key, reflect.TypeOf(data))
+		if errs[i] = beforeSave(c, data, create); errs[i] != nil {
+			hasErr = true
+			continue
+		}
+		if v, ok := data.(Versioner); ok && create && v.ModelVersion() == 0 {
+			v.SetModelVersion(1)
+		}
+	}
+	if hasErr {
+		return nil, errs
+	}
+
+	b := s.backend(c)
+	var ret []*datastore.Key
+	var err error
+	if sv.Len() > 0 && isUniquer(elemPointer(sv.Index(0))) {
+		// each model's unique values are reserved within its own transaction
+		ret = make([]*datastore.Key, len(keys))
+		for i, key := range keys {
+			if ret[i], err = s.putUnique(c, key, elemPointer(sv.Index(i))); err != nil {
+				break
+			}
+		}
+	} else {
+		ret, err = b.PutMulti(c, keys, src)
+	}
+
+	var cacheKeys []*datastore.Key
+	for _, key := range keys {
+		if key != nil && !key.Incomplete() {
+			cacheKeys = append(cacheKeys, key)
+		}
+	}
+	s.clearCache(c, cacheKeys...)
+	if err != nil {
+		return ret, err
+	}
+
+	for i, key := range ret {
+		action := HistoryUpdate
+		if olds[i] == nil {
+			action = HistoryCreate
+		}
+		data := elemPointer(sv.Index(i))
+		if err = s.record(c, key, action, olds[i], data); err != nil {
+			return ret, err
+		}
+		s.index(c, key, data)
+		event := EventUpdated
+		if keys[i] == nil || keys[i].Incomplete() {
+			event = EventCreated
+		}
+		s.publish(c, event, key, prevs[i], data)
+	}
+	return ret, nil
+}
+
+// DeleteMulti is a batch version of Delete that deletes the records and clears
+// the cached data of each
+func (s Store) DeleteMulti(c context.Context, keys []*datastore.Key) error {
+	if err := checkTenant(c, keys...); err != nil {
+		return err
+	}
+	if isSoftDeleter(s.Model) || isUniquer(s.Model) {
+		for _, key := range keys {
+			if err := s.Delete(c, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, key := range keys {
+		if err := s.beforeDelete(c, key); err != nil {
+			return err
+		}
+	}
+	olds := make([][]datastore.Property, len(keys))
+	prevs := make([]interface{}, len(keys))
+	for i, key := range keys {
+		var err error
+		if olds[i], err = s.savedProperties(c, key); err != nil {
+			return err
+		}
+		prevs[i] = s.loadPrevious(c, key, reflect.TypeOf(s.Model))
+	}
+	b := s.backend(c)
+	err := b.DeleteMulti(c, keys)
+	// keys may have been deleted even on a partial failure
+	s.clearCache(c, keys...)
+	if err != nil {
+		return err
+	}
+
+	for i, key := range keys {
+		s.unindex(c, key)
+		if olds[i] != nil {
+			if err = s.record(c, key, HistoryDelete, olds[i], nil); err != nil {
+				return err
+			}
+		}
+		s.publish(c, EventDeleted, key, prevs[i], nil)
+	}
+	return nil
+}
+
+// clearCache deletes the cached models of the keys once the transaction of the
+// context has committed
+func (s Store) clearCache(c context.Context, keys ...*datastore.Key) {
+	if len(keys) == 0 {
+		return
+	}
+	afterCommit(c, func(c context.Context) {
+		s.backend(c).CacheDeleteMulti(c, encodeKeys(keys))
+	})
+}
+
+func encodeKeys(keys []*datastore.Key) []string {
+	encoded := make([]string, len(keys))
+	for i, key := range keys {
+		encoded[i] = key.Encode()
+	}
+	return encoded
+}
+
+// sliceValue returns the slice that dst points to
+func sliceValue(dst interface{}) (reflect.Value, error) {
+	dv := reflect.ValueOf(dst)
+	if dv.Kind() != reflect.Ptr || dv.Elem().Kind() != reflect.Slice {
+		return reflect.Value{}, datastore.ErrInvalidEntityType
+	}
+	return dv.Elem(), nil
+}
+
+// newSliceElem creates an addressable value of the slice's element type, with
+// struct pointers being allocated
+func newSliceElem(slice reflect.Value) reflect.Value {
+	t := slice.Type().Elem()
+	if t.Kind() == reflect.Ptr {
+		return reflect.New(t.Elem())
+	}
+	return reflect.New(t).Elem()
+}
+
+// elemPointer returns a pointer to the slice element, allocating nil pointers
+func elemPointer(v reflect.Value) interface{} {
+	switch v.Kind() {
+	case reflect.Ptr:
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		return v.Interface()
+	case reflect.Interface:
+		return v.Elem().Interface()
+	default:
+		return v.Addr().Interface()
+	}
 }
@@ -1,10 +1,17 @@
 package ae
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/chrisolsen/ae/testutils"
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
 	"google.golang.org/appengine/datastore"
 	"google.golang.org/appengine/memcache"
 )
@@ -177,3 +184,812 @@ func TestGetFromMemcache(t *testing.T) {
 		return
 	}
 }
+
+func TestGetMulti(t *testing.T) {
+	c := NewMemoryContext()
+	b := BackendFromContext(c)
+	s := Store{TableName: "users"}
+
+	type user struct {
+		Name string
+	}
+
+	var keys []*datastore.Key
+	for _, name := range []string{"Ann", "Bob", "Cal"} {
+		key, _ := s.Create(c, &user{Name: name}, nil)
+		keys = append(keys, key)
+	}
+	missingKey := datastore.NewKey(c, "users", "", 999, nil)
+
+	// prime the cache with one of the users
+	var u user
+	s.Get(c, keys[0], &u)
+
+	dst := make([]*user, 4)
+	err := s.GetMulti(c, append(keys, missingKey), dst)
+	me, ok := err.(appengine.MultiError)
+	if !ok {
+		t.Errorf("expected a MultiError: %v", err)
+		return
+	}
+	for i, name := range []string{"Ann", "Bob", "Cal"} {
+		if me[i] != nil || dst[i].Name != name {
+			t.Errorf("expected %s: %v %v", name, me[i], dst[i])
+		}
+	}
+	if me[3] != datastore.ErrNoSuchEntity {
+		t.Errorf("expected ErrNoSuchEntity for missing key: %v", me[3])
+	}
+
+	// misses are backfilled into the cache
+	cached := make([]user, 3)
+	if err := b.CacheGetMulti(c, encodeKeys(keys), cached); err != nil {
+		t.Errorf("models not cached: %v", err)
+	}
+}
+
+func TestPutDeleteMulti(t *testing.T) {
+	c := NewMemoryContext()
+	b := BackendFromContext(c)
+	s := Store{TableName: "users"}
+
+	type user struct {
+		Name string
+	}
+
+	users := []user{user{Name: "Ann"}, user{Name: "Bob"}}
+	keys, err := s.PutMulti(c, []*datastore.Key{
+		datastore.NewIncompleteKey(c, "users", nil),
+		datastore.NewIncompleteKey(c, "users", nil),
+	}, users)
+	if err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+	s.GetMulti(c, keys, make([]user, 2))
+
+	users[0].Name, users[1].Name = "Abe", "Ben"
+	if _, err = s.PutMulti(c, keys, users); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+	var u user
+	if err = b.CacheGet(c, keys[0].Encode(), &u); err != memcache.ErrCacheMiss {
+		t.Errorf("cache not cleared on update: %v", err)
+	}
+	dst := make([]user, 2)
+	s.GetMulti(c, keys, dst)
+	if dst[0].Name != "Abe" || dst[1].Name != "Ben" {
+		t.Errorf("stale values: %v", dst)
+	}
+
+	if err = s.DeleteMulti(c, keys); err != nil {
+		t.Errorf("failed to delete: %v", err)
+		return
+	}
+	if err = b.CacheGet(c, keys[1].Encode(), &u); err != memcache.ErrCacheMiss {
+		t.Errorf("cache not cleared on delete: %v", err)
+	}
+	if err = s.GetMulti(c, keys, dst); err == nil {
+		t.Error("models not deleted")
+	}
+}
+
+func TestGetPage(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "users"}
+
+	type user struct {
+		Name string
+	}
+	for _, name := range []string{"Eve", "Ann", "Dan", "Bob", "Cal"} {
+		s.Create(c, &user{Name: name}, nil)
+	}
+
+	for _, hydrate := range []bool{false, true} {
+		q := s.Query().Order("Name").Limit(2)
+		if hydrate {
+			q = q.Hydrate()
+		}
+
+		var names []string
+		var cursor string
+		for pages := 0; pages < 5; pages++ {
+			var users []user
+			page, err := s.GetPage(c, q.Start(cursor), &users)
+			if err != nil {
+				t.Errorf("failed to get page: %v", err)
+				return
+			}
+			if len(page.Keys) != len(users) {
+				t.Errorf("key and model count mismatch: %d <=> %d", len(page.Keys), len(users))
+				return
+			}
+			for _, u := range users {
+				names = append(names, u.Name)
+			}
+			if page.Cursor == "" {
+				break
+			}
+			cursor = page.Cursor
+		}
+
+		if strings.Join(names, ",") != "Ann,Bob,Cal,Dan,Eve" {
+			t.Errorf("unexpected paged results (hydrate: %v): %v", hydrate, names)
+		}
+	}
+
+	var users []user
+	if _, err := s.GetPage(c, s.Query().Start("not a cursor"), &users); err != ErrInvalidCursor {
+		t.Errorf("expected ErrInvalidCursor: %v", err)
+	}
+}
+
+func TestUpdateVersionConflict(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts"}
+
+	type post struct {
+		Model
+		Title string
+	}
+
+	p := post{Title: "first"}
+	key, _ := s.Create(c, &p, nil)
+	if p.Version != 1 {
+		t.Errorf("expected version 1 on create: %d", p.Version)
+	}
+
+	// two editors read the same version
+	var p1, p2 post
+	s.Get(c, key, &p1)
+	s.Get(c, key, &p2)
+
+	p1.Title = "p1"
+	if err := s.Update(c, key, &p1); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+	if p1.Version != 2 {
+		t.Errorf("expected version 2: %d", p1.Version)
+	}
+
+	p2.Title = "p2"
+	err := s.Update(c, key, &p2)
+	if _, ok := err.(ErrConflict); !ok {
+		t.Errorf("expected ErrConflict: %v", err)
+	}
+
+	var saved post
+	s.Get(c, key, &saved)
+	if saved.Title != "p1" {
+		t.Errorf("stale update overwrote the saved model: %v", saved.Title)
+	}
+}
+
+// recordingIndexer records the keys it indexes and unindexes
+type recordingIndexer struct {
+	calls []string
+}
+
+func (x *recordingIndexer) Index(c context.Context, key *datastore.Key, model interface{}) error {
+	if inTransaction(c) {
+		return errors.New("indexed within the transaction")
+	}
+	x.calls = append(x.calls, "index:"+key.String())
+	return nil
+}
+
+func (x *recordingIndexer) Unindex(c context.Context, key *datastore.Key) error {
+	if inTransaction(c) {
+		return errors.New("unindexed within the transaction")
+	}
+	x.calls = append(x.calls, "unindex:"+key.String())
+	return nil
+}
+
+func TestRunInTransaction(t *testing.T) {
+	c := NewMemoryContext()
+	x := &recordingIndexer{}
+	s := Store{TableName: "posts", Indexers: []Indexer{x}}
+
+	type post struct {
+		Model
+		Title string
+	}
+
+	key, _ := s.Create(c, &post{Title: "first"}, nil)
+	other, _ := s.Create(c, &post{Title: "other"}, nil)
+	failed := errors.New("failed")
+
+	type test struct {
+		fail     error
+		expected string
+	}
+
+	tests := []test{
+		test{fail: failed, expected: "first"},
+		test{expected: "second"},
+	}
+
+	var logged []string
+	defer func(fn func(context.Context, string, ...interface{})) { logStoreError = fn }(logStoreError)
+	logStoreError = func(c context.Context, format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+
+	var events []string
+	defer Subscribe(func(c context.Context, e Event) error {
+		if inTransaction(c) {
+			return errors.New("published within the transaction")
+		}
+		events = append(events, e.Action)
+		return nil
+	}, "posts")()
+
+	for _, test := range tests {
+		x.calls, events = nil, nil
+		// cached before the transaction, to be cleared once it commits
+		s.Get(c, key, &post{})
+		err := RunInTransaction(c, func(tc context.Context) error {
+			p := post{Title: "second"}
+			p.Version = 1
+			if err := s.Update(tc, key, &p); err != nil {
+				return err
+			}
+			if err := s.Delete(tc, other); err != nil {
+				return err
+			}
+			return test.fail
+		}, nil)
+		if err != test.fail {
+			t.Errorf("transaction returned %v, expected %v", err, test.fail)
+			continue
+		}
+
+		var p post
+		if _, err := s.Get(c, key, &p); err != nil || p.Title != test.expected {
+			t.Errorf("saved %q, expected %q: %v", p.Title, test.expected, err)
+		}
+		_, err = s.Get(c, other, &post{})
+		if deleted := err == datastore.ErrNoSuchEntity; deleted != (test.fail == nil) {
+			t.Errorf("other deleted: %v: %v", deleted, err)
+		}
+		if indexed := len(x.calls) == 2; indexed != (test.fail == nil) {
+			t.Errorf("indexed %v after the transaction returned %v", x.calls, test.fail)
+		}
+		if published := len(events) == 2; published != (test.fail == nil) {
+			t.Errorf("published %v after the transaction returned %v", events, test.fail)
+		}
+	}
+	if len(logged) > 0 {
+		t.Errorf("store errors: %v", logged)
+	}
+}
+
+func TestDeleteFunc(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts"}
+
+	type post struct {
+		Model
+		Title string
+	}
+
+	key, _ := s.Create(c, &post{Title: "foo"}, nil)
+	failed := errors.New("failed")
+
+	type test struct {
+		fail    error
+		deleted bool
+	}
+
+	tests := []test{
+		test{fail: failed},
+		test{deleted: true},
+	}
+
+	for i, test := range tests {
+		var p post
+		err := s.DeleteFunc(c, key, &p, func() error {
+			if p.Title != "foo" || p.Version != 1 {
+				t.Errorf("%d. invalid model loaded: %+v", i, p)
+			}
+			return test.fail
+		})
+		if err != test.fail {
+			t.Errorf("%d. returned %v, expected %v", i, err, test.fail)
+		}
+		_, err = s.Get(c, key, &post{})
+		if deleted := err == datastore.ErrNoSuchEntity; deleted != test.deleted {
+			t.Errorf("%d. deleted %v, expected %v", i, deleted, test.deleted)
+		}
+	}
+
+	if err := s.DeleteFunc(c, key, &post{}, func() error { return nil }); err != datastore.ErrNoSuchEntity {
+		t.Errorf("deleting a missing model returned %v", err)
+	}
+}
+
+func TestUpdateFunc(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts"}
+
+	type post struct {
+		Model
+		Title string
+		Views int
+	}
+
+	key, _ := s.Create(c, &post{Title: "first"}, nil)
+
+	// prime the cache
+	var p post
+	s.Get(c, key, &p)
+
+	err := s.UpdateFunc(c, key, &p, func() error {
+		p.Views++
+		return nil
+	})
+	if err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+
+	var saved post
+	s.Get(c, key, &saved)
+	if saved.Views != 1 || saved.Version != 2 {
+		t.Errorf("unexpected saved values: %+v", saved)
+	}
+
+	// a failed mutation saves nothing
+	err = s.UpdateFunc(c, key, &p, func() error {
+		p.Views = 100
+		return errors.New("failed")
+	})
+	if err == nil {
+		t.Error("expected the mutate error")
+	}
+
+	// stale client version
+	err = s.UpdateFunc(c, key, &p, func() error {
+		p.Title = "stale"
+		p.Version = 1
+		return nil
+	})
+	if _, ok := err.(ErrConflict); !ok {
+		t.Errorf("expected ErrConflict: %v", err)
+	}
+
+	saved = post{}
+	s.Get(c, key, &saved)
+	if saved.Views != 1 || saved.Title != "first" {
+		t.Errorf("failed updates were saved: %+v", saved)
+	}
+}
+
+type hookedPost struct {
+	Model
+	Title  string
+	Slug   string
+	loaded bool
+}
+
+var hookedPostDeletes int
+
+func (p *hookedPost) Valid() error {
+	if p.Title == "" {
+		return errors.New("title is required")
+	}
+	return nil
+}
+
+func (p *hookedPost) BeforeCreate(c context.Context) error {
+	p.Slug = strings.ToLower(p.Title)
+	return nil
+}
+
+func (p *hookedPost) AfterLoad(c context.Context) error {
+	p.loaded = true
+	return nil
+}
+
+func (p *hookedPost) BeforeDelete(c context.Context) error {
+	hookedPostDeletes++
+	return nil
+}
+
+func TestModelHooks(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &hookedPost{}}
+
+	_, err := s.Create(c, &hookedPost{}, nil)
+	if _, ok := err.(ErrModelValidation); !ok {
+		t.Errorf("expected ErrModelValidation: %v", err)
+	}
+
+	key, err := s.Create(c, &hookedPost{Title: "Hello"}, nil)
+	if err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+
+	var p hookedPost
+	s.Get(c, key, &p)
+	if p.Slug != "hello" || !p.loaded || !p.Key.Equal(key) {
+		t.Errorf("hooks not called: %+v", p)
+	}
+
+	p.Title = ""
+	if _, ok := s.Update(c, key, &p).(ErrModelValidation); !ok {
+		t.Error("update not validated")
+	}
+
+	var posts []hookedPost
+	s.GetAll(c, s.Query(), &posts)
+	if len(posts) != 1 || !posts[0].loaded || !posts[0].Key.Equal(key) {
+		t.Errorf("query results not loaded: %+v", posts)
+	}
+
+	s.Delete(c, key)
+	if hookedPostDeletes != 1 {
+		t.Error("BeforeDelete not called")
+	}
+}
+
+type archivedPost struct {
+	Model
+	Timestamps
+	SoftDelete
+	Title string
+}
+
+// unversionedPost is updated without a transaction
+type unversionedPost struct {
+	Timestamps
+	SoftDelete
+	Title string
+}
+
+func TestTimestamps(t *testing.T) {
+	c := NewMemoryContext()
+	s := NewStore("posts")
+
+	now := time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)
+	timeNow = func() time.Time { return now }
+	defer func() { timeNow = time.Now }()
+
+	key, _ := s.Create(c, &archivedPost{Title: "foo"}, nil)
+
+	now = now.Add(time.Hour)
+	p := archivedPost{Title: "bar"}
+	p.Version = 1
+	if err := s.Update(c, key, &p); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+
+	var saved archivedPost
+	s.Get(c, key, &saved)
+	if !saved.CreatedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("created at not kept: %v", saved.CreatedAt)
+	}
+	if !saved.UpdatedAt.Equal(now) {
+		t.Errorf("updated at not set: %v", saved.UpdatedAt)
+	}
+
+	key, _ = s.Create(c, &unversionedPost{Title: "foo"}, nil)
+	now = now.Add(time.Hour)
+	if err := s.Update(c, key, &unversionedPost{Title: "bar"}); err != nil {
+		t.Errorf("failed to update unversioned: %v", err)
+		return
+	}
+	var unversioned unversionedPost
+	s.Get(c, key, &unversioned)
+	if !unversioned.CreatedAt.Equal(now.Add(-time.Hour)) {
+		t.Errorf("unversioned created at not kept: %v", unversioned.CreatedAt)
+	}
+}
+
+func TestSoftDelete(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &archivedPost{}}
+
+	key, _ := s.Create(c, &archivedPost{Title: "foo"}, nil)
+	s.Create(c, &archivedPost{Title: "bar"}, nil)
+
+	// cache the model to ensure the delete clears it
+	var p archivedPost
+	s.Get(c, key, &p)
+
+	if err := s.Delete(c, key); err != nil {
+		t.Errorf("failed to delete: %v", err)
+		return
+	}
+	if _, err := s.Get(c, key, &p); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expected ErrNoSuchEntity: %v", err)
+	}
+	if err := s.Update(c, key, &archivedPost{Title: "baz"}); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expected ErrNoSuchEntity updating deleted: %v", err)
+	}
+	unversionedKey, _ := s.Create(c, &unversionedPost{Title: "qux"}, nil)
+	s.Delete(c, unversionedKey)
+	if err := s.Update(c, unversionedKey, &unversionedPost{Title: "quux"}); err != datastore.ErrNoSuchEntity {
+		t.Errorf("expected ErrNoSuchEntity updating unversioned deleted: %v", err)
+	}
+	s.Purge(c, unversionedKey)
+
+	var posts []archivedPost
+	s.GetAll(c, s.Query(), &posts)
+	if len(posts) != 1 || posts[0].Title != "bar" {
+		t.Errorf("deleted post not excluded: %+v", posts)
+	}
+	keys, _ := s.GetAll(c, s.Query().WithDeleted().KeysOnly(), nil)
+	if len(keys) != 2 {
+		t.Errorf("%d keys found with deleted, expected %d", len(keys), 2)
+	}
+
+	if err := s.Restore(c, key); err != nil {
+		t.Errorf("failed to restore: %v", err)
+		return
+	}
+	if _, err := s.Get(c, key, &p); err != nil || p.IsDeleted() {
+		t.Errorf("post not restored: %v", err)
+	}
+
+	if err := s.Purge(c, key); err != nil {
+		t.Errorf("failed to purge: %v", err)
+		return
+	}
+	keys, _ = s.GetAll(c, s.Query().WithDeleted().KeysOnly(), nil)
+	if len(keys) != 1 {
+		t.Errorf("%d keys found after purge, expected %d", len(keys), 1)
+	}
+}
+
+func TestAudit(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &archivedPost{}, Audit: true}
+
+	accountKey := datastore.NewKey(c, "accounts", "", 1, nil)
+	c = WithActor(c, accountKey)
+
+	p := archivedPost{Title: "foo"}
+	key, _ := s.Create(c, &p, nil)
+	p.Title = "bar"
+	if err := s.Update(c, key, &p); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+	s.Delete(c, key)
+	s.Restore(c, key)
+
+	// the history of child entities isn't part of the model's history
+	comments := Store{TableName: "comments", Model: &archivedPost{}, Audit: true}
+	comments.Create(c, &archivedPost{Title: "baz"}, key)
+
+	history, err := s.History(c, key)
+	if err != nil {
+		t.Errorf("failed to get history: %v", err)
+		return
+	}
+
+	type test struct {
+		action  string
+		changed string
+	}
+
+	tests := []test{
+		test{action: HistoryCreate, changed: "Title"},
+		test{action: HistoryUpdate, changed: "Title"},
+		test{action: HistoryDelete, changed: "Deleted"},
+		test{action: HistoryRestore, changed: "Deleted"},
+	}
+
+	if len(history) != len(tests) {
+		t.Errorf("%d revisions, expected %d", len(history), len(tests))
+		return
+	}
+	for i, test := range tests {
+		h := history[i]
+		if h.Revision != int64(i+1) || h.Action != test.action || !h.AccountKey.Equal(accountKey) {
+			t.Errorf("invalid revision %d: %+v", i+1, h)
+			continue
+		}
+		data, _ := json.Marshal(h)
+		if strings.Contains(string(data), h.Key.Encode()) || strings.Contains(string(data), accountKey.Encode()) ||
+			h.ID != EncodeID(h.Key) || h.AccountID != EncodeID(accountKey) {
+			t.Errorf("revision %d keys not public IDs: %s", i+1, data)
+		}
+		var changed bool
+		for _, change := range h.Changes {
+			changed = changed || change.Field == test.changed
+		}
+		if !changed {
+			t.Errorf("%s missing from changes of revision %d: %+v", test.changed, i+1, h.Changes)
+		}
+	}
+	for _, change := range history[1].Changes {
+		if change.Field == "Title" && (change.Old != "foo" || change.New != "bar") {
+			t.Errorf("invalid change: %+v", change)
+		}
+	}
+
+	var reverted archivedPost
+	if err = s.Revert(c, key, 1, &reverted); err != nil {
+		t.Errorf("failed to revert: %v", err)
+		return
+	}
+	var saved archivedPost
+	s.Get(c, key, &saved)
+	if saved.Title != "foo" || saved.Version != 3 {
+		t.Errorf("model not reverted: %+v", saved)
+	}
+
+	// the revisions of history recorded without a head continue on from it
+	s.backend(c).Delete(c, historyHeadKey(c, key))
+	saved.Title = "qux"
+	s.Update(c, key, &saved)
+	if history, _ = s.History(c, key); len(history) != 6 || history[5].Revision != 6 {
+		t.Errorf("invalid revisions after the legacy history: %+v", history)
+	}
+}
+
+type uniqueUser struct {
+	Model
+	Email string
+}
+
+func (u *uniqueUser) UniqueValues() map[string]string {
+	return map[string]string{"Email": u.Email}
+}
+
+func TestUnique(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "users", Model: &uniqueUser{}}
+
+	key, err := s.Create(c, &uniqueUser{Email: "jim@example.com"}, nil)
+	if err != nil {
+		t.Errorf("failed to create: %v", err)
+		return
+	}
+	_, err = s.Create(c, &uniqueUser{Email: "jim@example.com"}, nil)
+	if e, ok := err.(ErrDuplicateValue); !ok || e.Field != "Email" {
+		t.Errorf("expected ErrDuplicateValue: %v", err)
+		return
+	}
+
+	// changing the value releases the old value
+	u := uniqueUser{Email: "jimmy@example.com"}
+	if err = s.Update(c, key, &u); err != nil {
+		t.Errorf("failed to update: %v", err)
+		return
+	}
+	other, err := s.Create(c, &uniqueUser{Email: "jim@example.com"}, nil)
+	if err != nil {
+		t.Errorf("old value not released: %v", err)
+		return
+	}
+
+	err = s.UpdateFunc(c, key, &u, func() error {
+		u.Email = "jim@example.com"
+		return nil
+	})
+	if _, ok := err.(ErrDuplicateValue); !ok {
+		t.Errorf("expected ErrDuplicateValue on UpdateFunc: %v", err)
+	}
+
+	// deleting releases the value
+	s.Delete(c, other)
+	if _, err = s.Create(c, &uniqueUser{Email: "jim@example.com"}, nil); err != nil {
+		t.Errorf("value not released on delete: %v", err)
+	}
+}
+
+func TestEvents(t *testing.T) {
+	c := NewMemoryContext()
+	s := Store{TableName: "posts", Model: &archivedPost{}}
+
+	var events []Event
+	unsubscribe := Subscribe(func(c context.Context, e Event) error {
+		events = append(events, e)
+		return nil
+	}, "posts")
+	defer unsubscribe()
+
+	var async []string
+	defer func(fn func(context.Context, string, Event) error) { callAsync = fn }(callAsync)
+	callAsync = func(c context.Context, name string, e Event) error {
+		async = append(async, name+":"+e.Action)
+		return nil
+	}
+	defer SubscribeAsync("webhooks", func(c context.Context, e Event) error { return nil }, "comments")()
+
+	key, _ := s.Create(c, &archivedPost{Title: "foo"}, nil)
+	s.Update(c, key, &archivedPost{Title: "bar"})
+	s.Delete(c, key)
+	NewStore("comments").Create(c, &archivedPost{}, nil)
+
+	type test struct {
+		action string
+		old    string
+		new    string
+	}
+
+	tests := []test{
+		test{action: EventCreated, new: "foo"},
+		test{action: EventUpdated, old: "foo", new: "bar"},
+		test{action: EventDeleted, old: "bar"},
+	}
+
+	if len(events) != len(tests) {
+		t.Errorf("%d events, expected %d", len(events), len(tests))
+		return
+	}
+	for i, test := range tests {
+		e := events[i]
+		if e.Action != test.action || !e.Key.Equal(key) {
+			t.Errorf("invalid event %d: %+v", i, e)
+			continue
+		}
+		if old, _ := e.Old.(*archivedPost); (old == nil && test.old != "") || (old != nil && old.Title != test.old) {
+			t.Errorf("invalid old value of event %d: %+v", i, e.Old)
+		}
+		if model, _ := e.New.(*archivedPost); (model == nil && test.new != "") || (model != nil && model.Title != test.new) {
+			t.Errorf("invalid new value of event %d: %+v", i, e.New)
+		}
+	}
+
+	if len(async) != 1 || async[0] != "webhooks:"+EventCreated {
+		t.Errorf("invalid async events: %v", async)
+	}
+}
+
+func TestEventsFailure(t *testing.T) {
+	c := NewMemoryContext()
+	s := NewStore("posts")
+
+	var logged []string
+	defer func(fn func(context.Context, string, ...interface{})) { logStoreError = fn }(logStoreError)
+	logStoreError = func(c context.Context, format string, args ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, args...))
+	}
+	defer Subscribe(func(c context.Context, e Event) error { return errors.New("failed") }, "posts")()
+
+	// the write has been committed once the subscribers are called
+	key, err := s.Create(c, &archivedPost{Title: "foo"}, nil)
+	if err != nil || key == nil {
+		t.Fatalf("create failed: %v", err)
+	}
+	if _, err := s.Get(c, key, &archivedPost{}); err != nil {
+		t.Errorf("post not saved: %v", err)
+	}
+	if len(logged) != 1 || !strings.Contains(logged[0], "failed") {
+		t.Errorf("invalid logged errors: %v", logged)
+	}
+}
+
+type taggedPost struct {
+	Model
+	Title string `json:"title" validate:"required,max=10"`
+	State string `json:"state" validate:"oneof=draft|published"`
+}
+
+func TestValidateTags(t *testing.T) {
+	c := NewMemoryContext()
+	s := NewStore("posts")
+
+	_, err := s.Create(c, &taggedPost{State: "archived"}, nil)
+	ve, ok := err.(ErrModelValidation)
+	if !ok {
+		t.Errorf("expected ErrModelValidation: %v", err)
+		return
+	}
+	if len(ve.Fields) != 2 || ve.Fields["title"] != "is required" || ve.Fields["state"] != "must be one of draft, published" {
+		t.Errorf("invalid fields: %v", ve.Fields)
+	}
+
+	if _, err = s.Create(c, &taggedPost{Title: "hello", State: "draft"}, nil); err != nil {
+		t.Errorf("failed to create: %v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package ae
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// the interval of the comments keeping idle streams open when none is set
+const defaultHeartbeat = 15 * time.Second
+
+// ErrStreamingUnsupported is returned by Stream when the response can't be
+// flushed
+var ErrStreamingUnsupported = errors.New("streaming unsupported by the response writer")
+
+// StreamOptions contains the optional settings of Stream
+type StreamOptions struct {
+	// Heartbeat is the interval of the comments sent to keep the connection
+	// open, which defaults to 15 seconds
+	Heartbeat time.Duration
+
+	// Retry is the time clients wait before reconnecting, which is left to the
+	// client if it isn't set
+	Retry time.Duration
+}
+
+// StreamEvent is a server-sent event, where data other than strings and bytes
+// is sent as JSON
+type StreamEvent struct {
+	ID   string
+	Name string
+	Data interface{}
+}
+
+// EventStream writes server-sent events to the response
+type EventStream struct {
+	// LastEventID is the ID of the last event received by a reconnecting
+	// client, for the stream to resume after it
+	LastEventID string
+
+	c       context.Context
+	mu      sync.Mutex
+	w       http.ResponseWriter
+	flusher http.Flusher
+}
+
+// Stream responds with server-sent events, sent by the function until it
+// returns or the request is cancelled, with heartbeats keeping the connection
+// open in between. The stream's context is cancelled when the client
+// disconnects, after which sending events fails.
+//  h.Stream(ae.StreamOptions{}, func(c context.Context, s *ae.EventStream) error {
+//  	for {
+//  		select {
+//  		case <-c.Done():
+//  			return nil
+//  		case stat := <-stats:
+//  			if err := s.Send(ae.StreamEvent{ID: stat.ID, Name: "stat", Data: stat}); err != nil {
+//  				return err
+//  			}
+//  		}
+//  	}
+//  })
+func (h *Handler) Stream(opts StreamOptions, fn func(c context.Context, s *EventStream) error) error {
+	flusher, ok := h.Res.(http.Flusher)
+	if !ok {
+		h.Abort(http.StatusInternalServerError, ErrStreamingUnsupported)
+		return ErrStreamingUnsupported
+	}
+
+	c, cancel := context.WithCancel(h.Ctx)
+	defer cancel()
+	if cn, ok := h.Res.(http.CloseNotifier); ok {
+		closed := cn.CloseNotify()
+		go func() {
+			select {
+			case <-closed:
+				cancel()
+			case <-c.Done():
+			}
+		}()
+	}
+
+	lastEventID := h.Req.Header.Get("Last-Event-ID")
+	if lastEventID == "" {
+		lastEventID = h.Req.URL.Query().Get("lastEventId")
+	}
+	s := &EventStream{LastEventID: lastEventID, c: c, w: h.Res, flusher: flusher}
+
+	header := h.Res.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	h.Res.WriteHeader(http.StatusOK)
+	if opts.Retry > 0 {
+		s.write(fmt.Sprintf("retry: %d\n\n", opts.Retry/time.Millisecond))
+	} else {
+		flusher.Flush()
+	}
+
+	heartbeat := opts.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = defaultHeartbeat
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(heartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-c.Done():
+				return
+			case <-ticker.C:
+				s.Comment("heartbeat")
+			}
+		}
+	}()
+
+	// nothing is written once the function returns
+	err := fn(c, s)
+	cancel()
+	wg.Wait()
+	return err
+}
+
+// Send writes the event and flushes it to the client, failing once the stream
+// is cancelled
+func (s *EventStream) Send(e StreamEvent) error {
+	var data string
+	switch d := e.Data.(type) {
+	case string:
+		data = d
+	case []byte:
+		data = string(d)
+	default:
+		b, err := json.Marshal(d)
+		if err != nil {
+			return err
+		}
+		data = string(b)
+	}
+
+	var msg []string
+	if e.ID != "" {
+		msg = append(msg, "id: "+singleLine(e.ID))
+	}
+	if e.Name != "" {
+		msg = append(msg, "event: "+singleLine(e.Name))
+	}
+	for _, line := range strings.Split(strings.Replace(data, "\r\n", "\n", -1), "\n") {
+		msg = append(msg, "data: "+line)
+	}
+	return s.write(strings.Join(msg, "\n") + "\n\n")
+}
+
+// Comment writes a comment, which clients ignore
+func (s *EventStream) Comment(text string) error {
+	return s.write(": " + singleLine(text) + "\n\n")
+}
+
+func (s *EventStream) write(msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.c.Err(); err != nil {
+		return err
+	}
+	if _, err := fmt.Fprint(s.w, msg); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
+
+// singleLine removes the line breaks that would end a field
+func singleLine(s string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(s)
+}
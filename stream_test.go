@@ -0,0 +1,108 @@
+package ae
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+func TestStream(t *testing.T) {
+	type test struct {
+		lastEventID string
+		opts        StreamOptions
+		events      []StreamEvent
+		wait        time.Duration
+		body        string
+	}
+
+	tests := []test{
+		test{
+			events: []StreamEvent{
+				StreamEvent{ID: "1", Name: "post", Data: map[string]int{"views": 2}},
+				StreamEvent{Data: "foo\nbar"},
+			},
+			body: "id: 1\nevent: post\ndata: {\"views\":2}\n\ndata: foo\ndata: bar\n\n",
+		},
+		test{
+			lastEventID: "1",
+			opts:        StreamOptions{Retry: 3 * time.Second},
+			events:      []StreamEvent{StreamEvent{ID: "2\n", Data: []byte("baz")}},
+			body:        "retry: 3000\n\nid: 2\ndata: baz\n\n",
+		},
+		test{
+			opts: StreamOptions{Heartbeat: 10 * time.Millisecond},
+			wait: 50 * time.Millisecond,
+			body: ": heartbeat\n\n",
+		},
+	}
+
+	for i, test := range tests {
+		r, _ := http.NewRequest("GET", "/stats", nil)
+		r.Header.Set("Last-Event-ID", test.lastEventID)
+		w := httptest.NewRecorder()
+		h := DefaultHandler()
+		h.Bind(NewMemoryContext(), w, r)
+
+		err := h.Stream(test.opts, func(c context.Context, s *EventStream) error {
+			if s.LastEventID != test.lastEventID {
+				t.Errorf("%d. last event id %s, expected %s", i, s.LastEventID, test.lastEventID)
+			}
+			for _, e := range test.events {
+				if err := s.Send(e); err != nil {
+					return err
+				}
+			}
+			time.Sleep(test.wait)
+			return nil
+		})
+		if err != nil {
+			t.Errorf("%d. unexpected error: %v", i, err)
+			continue
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "text/event-stream" || !w.Flushed {
+			t.Errorf("%d. content type %s, flushed %v", i, ct, w.Flushed)
+		}
+		if !strings.HasPrefix(w.Body.String(), test.body) {
+			t.Errorf("%d. body %q, expected %q", i, w.Body, test.body)
+		}
+	}
+}
+
+func TestStreamCancel(t *testing.T) {
+	c, cancel := context.WithCancel(NewMemoryContext())
+	r, _ := http.NewRequest("GET", "/stats", nil)
+	w := httptest.NewRecorder()
+	h := DefaultHandler()
+	h.Bind(c, w, r)
+
+	var sent int
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		cancel()
+	}()
+	err := h.Stream(StreamOptions{}, func(c context.Context, s *EventStream) error {
+		for {
+			if err := s.Send(StreamEvent{Data: "tick"}); err != nil {
+				return err
+			}
+			sent++
+			time.Sleep(time.Millisecond)
+		}
+	})
+	if err != context.Canceled || sent == 0 {
+		t.Errorf("stream ended with %v after %d events", err, sent)
+	}
+
+	// the function's errors are returned
+	expected := errors.New("failed")
+	w = httptest.NewRecorder()
+	h.Bind(NewMemoryContext(), w, r)
+	if err := h.Stream(StreamOptions{}, func(c context.Context, s *EventStream) error { return expected }); err != expected {
+		t.Errorf("returned %v, expected %v", err, expected)
+	}
+}
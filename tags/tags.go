@@ -10,6 +10,8 @@ import (
 
 const tableName = "tags"
 
+var store = ae.NewStore(tableName)
+
 // Tag allows better searching capabilities with AppEngine's Datastore
 type Tag struct {
 	ae.Model
@@ -41,10 +43,7 @@ func Save(c context.Context, rawTags []string, tagType string, parentKey *datast
 
 	// get existing
 	var existingTags []*Tag
-	oldKeys, err := datastore.NewQuery(tableName).
-		Ancestor(parentKey).
-		Filter("Type =", tagType).
-		GetAll(c, &existingTags)
+	oldKeys, err := store.GetAll(c, store.Query().Ancestor(parentKey).Filter("Type =", tagType), &existingTags)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -59,9 +58,11 @@ func Save(c context.Context, rawTags []string, tagType string, parentKey *datast
 	}
 
 	rmTagKeys := getTagsToRemove(existingTags, newTagMap)
-	err = datastore.DeleteMulti(c, rmTagKeys)
-	if err != nil {
-		return 0, 0, err
+	if len(rmTagKeys) > 0 {
+		err = store.DeleteMulti(c, rmTagKeys)
+		if err != nil {
+			return 0, 0, err
+		}
 	}
 	delCount = len(rmTagKeys)
 
@@ -71,10 +72,14 @@ func Save(c context.Context, rawTags []string, tagType string, parentKey *datast
 		existingTagMap[tag.Value] = true
 	}
 	newTags := getTagsToAdd(rawTags, existingTagMap)
-	for _, t := range newTags {
-		tg := &Tag{Value: strings.ToLower(t), Type: tagType}
-		ky := datastore.NewIncompleteKey(c, tableName, parentKey)
-		_, err = datastore.Put(c, ky, tg)
+	if len(newTags) > 0 {
+		tags := make([]*Tag, len(newTags))
+		keys := make([]*datastore.Key, len(newTags))
+		for i, t := range newTags {
+			tags[i] = &Tag{Value: strings.ToLower(t), Type: tagType}
+			keys[i] = datastore.NewIncompleteKey(c, tableName, parentKey)
+		}
+		_, err = store.PutMulti(c, keys, tags)
 		if err != nil {
 			return 0, 0, err
 		}
@@ -85,29 +90,43 @@ func Save(c context.Context, rawTags []string, tagType string, parentKey *datast
 
 // FindKeysByTag returns a list of all the tag's parent datastore keys
 func FindKeysByTag(c context.Context, tag, tagType string, parentKey *datastore.Key, offset, limit int) ([]*datastore.Key, error) {
-	filter := strings.ToLower(tag)
-	q := datastore.NewQuery(tableName)
+	keys, err := store.GetAll(c, tagQuery(tag, tagType, parentKey).Offset(offset).Limit(limit), nil)
+	if err != nil {
+		return nil, err
+	}
+	return parentKeys(keys), nil
+}
+
+// FindKeysByTagPage returns a page of the tag's parent datastore keys along with
+// the cursor of the next page, which is empty once there are no more results.
+// Unlike the offset of FindKeysByTag, paging with a cursor doesn't slow down
+// with each page.
+func FindKeysByTagPage(c context.Context, tag, tagType string, parentKey *datastore.Key, cursor string, limit int) ([]*datastore.Key, string, error) {
+	page, err := store.GetPage(c, tagQuery(tag, tagType, parentKey).Start(cursor).Limit(limit), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	return parentKeys(page.Keys), page.Cursor, nil
+}
+
+func tagQuery(tag, tagType string, parentKey *datastore.Key) *ae.Query {
+	q := store.Query()
 	if parentKey != nil {
 		q = q.Ancestor(parentKey)
 	}
-
-	keys, err := q.
+	return q.
 		Filter("Type =", tagType).
-		Filter("Value =", filter).
+		Filter("Value =", strings.ToLower(tag)).
 		Order("__key__").
-		Offset(offset).
-		Limit(limit).
-		KeysOnly().
-		GetAll(c, nil)
+		KeysOnly()
+}
 
-	if err != nil {
-		return nil, err
-	}
+func parentKeys(keys []*datastore.Key) []*datastore.Key {
 	parentKeys := make([]*datastore.Key, len(keys))
 	for i, k := range keys {
 		parentKeys[i] = k.Parent()
 	}
-	return parentKeys, err
+	return parentKeys
 }
 
 func getTagsToRemove(currentTags []*Tag, newTags map[string]bool) []*datastore.Key {
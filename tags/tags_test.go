@@ -3,27 +3,23 @@ package tags
 import (
 	"testing"
 
-	"github.com/chrisolsen/ae/testutils"
+	"github.com/chrisolsen/ae"
 	"google.golang.org/appengine/datastore"
 )
 
 func TestSave(t *testing.T) {
-	utils := testutils.T{}
-	c := utils.GetContext()
-	defer utils.Close()
+	c := ae.NewMemoryContext()
 
 	// need a parent
-	key := datastore.NewIncompleteKey(c, "tags", nil)
-	parentKey, _ := datastore.Put(c, key, &Tag{Value: "parent", Type: "parent"})
+	parentKey, _ := store.Create(c, &Tag{Value: "parent", Type: "parent"}, nil)
 
 	// init existing tags
 	for _, tag := range []string{"rm1", "rm2", "keep"} {
-		key := datastore.NewIncompleteKey(c, "tags", parentKey)
-		datastore.Put(c, key, &Tag{Value: tag, Type: "sometype", Public: true})
+		store.Create(c, &Tag{Value: tag, Type: "sometype"}, parentKey)
 	}
 
 	// save => create new tags
-	delCount, addCount, _ := Save(c, []string{"keep", "new"}, "sometype", true, parentKey)
+	delCount, addCount, _ := Save(c, []string{"keep", "new"}, "sometype", parentKey)
 	if delCount != 2 {
 		t.Errorf("%d deleted, expected %d", delCount, 2)
 	}
@@ -31,3 +27,56 @@ func TestSave(t *testing.T) {
 		t.Errorf("%d added, expected %d", addCount, 1)
 	}
 }
+
+func TestFindKeysByTagPage(t *testing.T) {
+	c := ae.NewMemoryContext()
+
+	var parentKeys []*datastore.Key
+	for i := 0; i < 5; i++ {
+		key := datastore.NewKey(c, "posts", "", int64(i+1), nil)
+		parentKeys = append(parentKeys, key)
+		Save(c, []string{"Foo"}, "sometype", key)
+	}
+
+	var found []*datastore.Key
+	var cursor string
+	for pages := 0; pages < 5; pages++ {
+		keys, next, err := FindKeysByTagPage(c, "foo", "sometype", nil, cursor, 2)
+		if err != nil {
+			t.Errorf("failed to find keys: %v", err)
+			return
+		}
+		found = append(found, keys...)
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+
+	if len(found) != len(parentKeys) {
+		t.Errorf("%d keys found, expected %d", len(found), len(parentKeys))
+		return
+	}
+	for i, key := range found {
+		if !key.Equal(parentKeys[i]) {
+			t.Errorf("key mismatch: %v <=> %v", key, parentKeys[i])
+		}
+	}
+}
+
+func TestSaveWithinTenant(t *testing.T) {
+	c := ae.NewMemoryContext()
+	acme, _ := ae.WithTenant(c, "acme")
+	other, _ := ae.WithTenant(c, "other")
+
+	parentKey := datastore.NewKey(acme, "posts", "", 1, nil)
+	Save(acme, []string{"foo"}, "sometype", parentKey)
+
+	if keys, _ := FindKeysByTag(other, "foo", "sometype", nil, 0, 10); len(keys) != 0 {
+		t.Errorf("%d keys found within other tenant", len(keys))
+	}
+	keys, err := FindKeysByTag(acme, "foo", "sometype", nil, 0, 10)
+	if err != nil || len(keys) != 1 || !keys[0].Equal(parentKey) {
+		t.Errorf("tag not found within tenant: %v %v", keys, err)
+	}
+}
@@ -0,0 +1,230 @@
+package ae
+
+import (
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/appengine"
+)
+
+// TemplateErrors lists the errors of each template that failed to parse
+type TemplateErrors []error
+
+func (e TemplateErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return fmt.Sprintf("%d broken templates:\n%s", len(e), strings.Join(msgs, "\n"))
+}
+
+// Templates is a registry of parsed views, each with its parent layouts, that
+// is safe for concurrent use by the handlers sharing it.
+//  var templates = ae.NewTemplates(config, helpers)
+//
+//  func init() {
+//  	if err := templates.ParseAll(); err != nil {
+//  		log.Fatal(err)
+//  	}
+//  }
+//
+//  h := ae.NewHandler(&ae.HandlerConfig{Templates: templates})
+type Templates struct {
+	// Reload re-parses the templates whose files have changed since they were
+	// parsed, which defaults to being enabled on the dev app server
+	Reload bool
+
+	config HandlerConfig
+	funcs  template.FuncMap
+
+	mu      sync.RWMutex
+	views   map[string]*parsedView
+	parents [][]string
+}
+
+type parsedView struct {
+	tmpl    *template.Template
+	files   []string
+	modTime time.Time
+}
+
+// NewTemplates creates a registry of the views and layouts under the config's
+// paths, parsed with the template functions
+func NewTemplates(config HandlerConfig, funcs template.FuncMap) *Templates {
+	setConfigDefaults(&config)
+	return &Templates{
+		Reload: appengine.IsDevAppServer(),
+		config: config,
+		funcs:  funcs,
+		views:  make(map[string]*parsedView),
+		// the views are rendered within the layout, and without any parents as
+		// the error page is
+		parents: [][]string{
+			[]string{filepath.Join(config.LayoutPath, config.LayoutFileName)},
+			nil,
+		},
+	}
+}
+
+// registries shared by the handlers without their own, by their paths
+var (
+	sharedMu        sync.Mutex
+	sharedTemplates = make(map[templatePaths]*Templates)
+)
+
+type templatePaths struct {
+	view, layout, layoutFileName string
+}
+
+// sharedRegistry returns the registry shared by the handlers with the config's
+// paths, which is parsed when it's created. The views that failed to parse,
+// such as those using the handlers' helpers, are parsed on their first lookup.
+func sharedRegistry(config HandlerConfig) *Templates {
+	setConfigDefaults(&config)
+	paths := templatePaths{config.ViewPath, config.LayoutPath, config.LayoutFileName}
+
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	t := sharedTemplates[paths]
+	if t == nil {
+		t = NewTemplates(config, nil)
+		t.ParseAll()
+		sharedTemplates[paths] = t
+	}
+	return t
+}
+
+// AddParents registers another set of parent layouts that the views are
+// rendered within, for ParseAll to parse the views within them as well
+//  templates.AddParents("layouts/application.html", "layouts/admin.html")
+func (t *Templates) AddParents(parents ...string) {
+	t.mu.Lock()
+	t.parents = append(t.parents, parents)
+	t.mu.Unlock()
+}
+
+// ParseAll parses every view under the ViewPath within each of the registered
+// parent sets, with the registry's functions, returning TemplateErrors listing
+// all the views that failed to parse. Parent sets with missing layout files are
+// skipped. The functions used by the views must be passed to NewTemplates, for
+// the views to be parsed as they are by the handlers.
+func (t *Templates) ParseAll() error {
+	var views []string
+	err := filepath.Walk(t.config.ViewPath, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if path == t.config.ViewPath && os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(path) == ".html" {
+			rel, err := filepath.Rel(t.config.ViewPath, path)
+			if err != nil {
+				return err
+			}
+			views = append(views, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(views)
+
+	t.mu.RLock()
+	var parentSets [][]string
+	for _, parents := range t.parents {
+		if filesExist(parents) {
+			parentSets = append(parentSets, parents)
+		}
+	}
+	t.mu.RUnlock()
+
+	var errs TemplateErrors
+	for _, name := range views {
+		for _, parents := range parentSets {
+			if _, err := t.Lookup(name, parents, t.funcs); err != nil {
+				errs = append(errs, err)
+				break
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// Lookup returns the view parsed with its parent templates, parsing it on the
+// first lookup. The registry's functions are used, or the passed in functions
+// if it has none.
+func (t *Templates) Lookup(name string, parents []string, funcs template.FuncMap) (*template.Template, error) {
+	name = strings.TrimPrefix(name, "/")
+	id := strings.Join(append(append([]string{}, parents...), name), "|")
+
+	t.mu.RLock()
+	v := t.views[id]
+	t.mu.RUnlock()
+	if v != nil && !(t.Reload && v.changed()) {
+		return v.tmpl, nil
+	}
+
+	var files []string
+	for _, p := range parents {
+		files = append(files, fileNameWithExt(p))
+	}
+	files = append(files, filepath.Join(t.config.ViewPath, fileNameWithExt(name)))
+
+	tmpl := template.New(name)
+	if t.funcs != nil {
+		tmpl.Funcs(t.funcs)
+	} else if funcs != nil {
+		tmpl.Funcs(funcs)
+	}
+	if _, err := tmpl.ParseFiles(files...); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	v = &parsedView{tmpl: tmpl, files: files, modTime: latestModTime(files)}
+
+	t.mu.Lock()
+	t.views[id] = v
+	t.mu.Unlock()
+	return tmpl, nil
+}
+
+// changed indicates if any of the view's files were modified since parsing
+func (v *parsedView) changed() bool {
+	return latestModTime(v.files).After(v.modTime)
+}
+
+func filesExist(files []string) bool {
+	for _, f := range files {
+		if _, err := os.Stat(fileNameWithExt(f)); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func latestModTime(files []string) time.Time {
+	var latest time.Time
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest
+}
+
+func fileNameWithExt(name string) string {
+	if strings.Index(name, ".") > 0 {
+		return name
+	}
+	return name + ".html"
+}
@@ -0,0 +1,189 @@
+package ae
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeTemplates(t *testing.T, dir string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTemplatesParseAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	type test struct {
+		files   map[string]string
+		funcs   template.FuncMap
+		parents []string
+		parsed  int
+		broken  []string
+	}
+
+	tests := []test{
+		test{
+			files: map[string]string{
+				"views/posts/index.html": `{{define "content"}}index{{end}}`,
+				"views/posts/show.html":  `{{define "content"}}{{.}}{{end}}`,
+			},
+			parsed: 4,
+		},
+		test{
+			files: map[string]string{
+				"views/posts/show.html": `{{define "content"}}{{.}}{{end}}`,
+				"layouts/admin.html":    `{{define "layout"}}<aside>{{template "content" .}}</aside>{{end}}`,
+			},
+			parents: []string{filepath.Join(dir, "layouts", "admin.html")},
+			parsed:  3,
+		},
+		test{
+			files: map[string]string{
+				"views/posts/index.html": `{{define "content"}}{{.Foo}{{end}}`,
+				"views/posts/show.html":  `{{define "content"}}{{end}}`,
+				"views/users/edit.html":  `{{define "content"}}{{unknownFunc}}{{end}}`,
+			},
+			broken: []string{"posts/index.html", "users/edit.html"},
+		},
+		test{
+			files:  map[string]string{"views/posts/show.html": `{{define "content"}}{{upper .}}{{end}}`},
+			funcs:  template.FuncMap{"upper": strings.ToUpper},
+			parsed: 2,
+		},
+	}
+
+	for i, test := range tests {
+		os.RemoveAll(dir)
+		writeTemplates(t, dir, test.files)
+		writeTemplates(t, dir, map[string]string{
+			"layouts/application.html": `{{define "layout"}}<main>{{template "content" .}}</main>{{end}}`,
+		})
+		templates := NewTemplates(HandlerConfig{
+			ViewPath:   filepath.Join(dir, "views"),
+			LayoutPath: filepath.Join(dir, "layouts"),
+		}, test.funcs)
+		if test.parents != nil {
+			templates.AddParents(test.parents...)
+		}
+
+		err := templates.ParseAll()
+		if len(test.broken) == 0 {
+			if err != nil {
+				t.Errorf("%d. unexpected error: %v", i, err)
+			}
+			if len(templates.views) != test.parsed {
+				t.Errorf("%d. %d views parsed, expected %d", i, len(templates.views), test.parsed)
+			}
+			continue
+		}
+		errs, ok := err.(TemplateErrors)
+		if !ok || len(errs) != len(test.broken) {
+			t.Errorf("%d. expected %d broken templates, got %v", i, len(test.broken), err)
+			continue
+		}
+		for j, name := range test.broken {
+			if !strings.HasPrefix(errs[j].Error(), name) {
+				t.Errorf("%d. expected error of %s, got %v", i, name, errs[j])
+			}
+		}
+	}
+}
+
+func TestTemplatesLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{
+		"views/posts/show.html":    `{{define "content"}}v1 {{.}}{{end}}`,
+		"layouts/application.html": `{{define "layout"}}<main>{{template "content" .}}</main>{{end}}`,
+	})
+
+	templates := NewTemplates(HandlerConfig{
+		ViewPath:   filepath.Join(dir, "views"),
+		LayoutPath: filepath.Join(dir, "layouts"),
+	}, nil)
+	layout := []string{filepath.Join(dir, "layouts", "application.html")}
+
+	render := func() string {
+		tmpl, err := templates.Lookup("/posts/show", layout, nil)
+		if err != nil {
+			return err.Error()
+		}
+		var buf bytes.Buffer
+		tmpl.ExecuteTemplate(&buf, "layout", "foo")
+		return buf.String()
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if out := render(); out != "<main>v1 foo</main>" {
+				t.Errorf("concurrent lookup rendered %q", out)
+			}
+		}()
+	}
+	wg.Wait()
+
+	type test struct {
+		reload   bool
+		expected string
+	}
+
+	tests := []test{
+		test{reload: false, expected: "<main>v1 foo</main>"},
+		test{reload: true, expected: "<main>v2 foo</main>"},
+	}
+
+	// modified in the future to avoid depending on the file system's precision
+	writeTemplates(t, dir, map[string]string{"views/posts/show.html": `{{define "content"}}v2 {{.}}{{end}}`})
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "views/posts/show.html"), later, later)
+
+	for _, test := range tests {
+		templates.Reload = test.reload
+		if out := render(); out != test.expected {
+			t.Errorf("reload %v: rendered %q, expected %q", test.reload, out, test.expected)
+		}
+	}
+}
+
+func TestNewHandlerTemplates(t *testing.T) {
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{
+		"error.html":      `{{.Status}} {{.Message}}`,
+		"posts/show.html": `{{define "content"}}{{.}}{{end}}`,
+	})
+
+	h := NewHandler(&HandlerConfig{ViewPath: dir})
+	other := NewHandler(&HandlerConfig{ViewPath: dir})
+	if h.templates != other.templates {
+		t.Errorf("handlers with the same paths don't share their templates")
+	}
+	if h.templates.views[errorTemplate] == nil || h.templates.views["posts/show.html"] == nil {
+		t.Errorf("views not parsed when created: %v", h.templates.views)
+	}
+}
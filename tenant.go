@@ -0,0 +1,136 @@
+package ae
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine"
+	"google.golang.org/appengine/datastore"
+)
+
+var (
+	tenantKey      = contextKey("tenant")
+	crossTenantKey = contextKey("cross-tenant")
+)
+
+// ErrTenantMismatch is returned by the Store when a key belongs to a tenant
+// other than the context's tenant
+type ErrTenantMismatch struct {
+	Key    *datastore.Key
+	Tenant string
+}
+
+func (e ErrTenantMismatch) Error() string {
+	return fmt.Sprintf("key %v does not belong to tenant %q", e.Key, e.Tenant)
+}
+
+// WithTenant returns a context in which the datastore, memcache and stores
+// operate within the tenant's namespace
+func WithTenant(c context.Context, tenant string) (context.Context, error) {
+	nc, err := appengine.Namespace(c, tenant)
+	if err != nil {
+		return nil, err
+	}
+	return context.WithValue(nc, tenantKey, tenant), nil
+}
+
+// TenantFromContext returns the tenant set with WithTenant, or an empty string
+func TenantFromContext(c context.Context) string {
+	tenant, _ := c.Value(tenantKey).(string)
+	return tenant
+}
+
+// CrossTenant returns a context in which stores are allowed to access the keys
+// of any tenant. This is intended for admin jobs that run across tenants, that
+// can switch between the tenants with WithTenant.
+//  c = ae.CrossTenant(c)
+//  for _, tenant := range tenants {
+//  	tc, _ := ae.WithTenant(c, tenant)
+//  	...
+//  }
+func CrossTenant(c context.Context) context.Context {
+	return context.WithValue(c, crossTenantKey, true)
+}
+
+// checkTenant ensures the keys belong to the context's tenant
+func checkTenant(c context.Context, keys ...*datastore.Key) error {
+	tenant, ok := c.Value(tenantKey).(string)
+	if !ok || c.Value(crossTenantKey) != nil {
+		return nil
+	}
+	for _, key := range keys {
+		if key != nil && key.Namespace() != tenant {
+			return ErrTenantMismatch{Key: key, Tenant: tenant}
+		}
+	}
+	return nil
+}
+
+// TenantResolver returns the tenant of the request, or an empty string if the
+// request doesn't contain one
+type TenantResolver func(r *http.Request) string
+
+// SubdomainTenant resolves the tenant from the subdomain of the domain
+//  ae.SubdomainTenant("example.com") // acme.example.com => acme
+func SubdomainTenant(domain string) TenantResolver {
+	suffix := "." + strings.TrimPrefix(domain, ".")
+	return func(r *http.Request) string {
+		host := r.Host
+		if i := strings.LastIndex(host, ":"); i > strings.LastIndex(host, "]") {
+			host = host[:i]
+		}
+		if !strings.HasSuffix(host, suffix) {
+			return ""
+		}
+		return strings.TrimSuffix(host, suffix)
+	}
+}
+
+// HeaderTenant resolves the tenant from the request header
+//  ae.HeaderTenant("X-Tenant")
+func HeaderTenant(name string) TenantResolver {
+	return func(r *http.Request) string {
+		return r.Header.Get(name)
+	}
+}
+
+// PathTenant resolves the tenant from the path segment following the prefix
+//  ae.PathTenant("/t/") // /t/acme/posts => acme
+func PathTenant(prefix string) TenantResolver {
+	return func(r *http.Request) string {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			return ""
+		}
+		tenant := strings.TrimPrefix(r.URL.Path, prefix)
+		if i := strings.Index(tenant, "/"); i >= 0 {
+			tenant = tenant[:i]
+		}
+		return tenant
+	}
+}
+
+// TenantMiddleware returns a middleware function that sets the tenant, of the
+// resolver, within the context. Requests without a tenant result in a 404, and
+// those with an invalid tenant in a 400.
+//  q := que.New(ae.TenantMiddleware(ae.SubdomainTenant("example.com")))
+func TenantMiddleware(resolve TenantResolver) func(context.Context, http.ResponseWriter, *http.Request) context.Context {
+	return func(c context.Context, w http.ResponseWriter, r *http.Request) context.Context {
+		tenant := resolve(r)
+		if tenant == "" {
+			w.WriteHeader(http.StatusNotFound)
+			c2, cancel := context.WithCancel(c)
+			cancel()
+			return c2
+		}
+		tc, err := WithTenant(c, tenant)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			c2, cancel := context.WithCancel(c)
+			cancel()
+			return c2
+		}
+		return tc
+	}
+}
@@ -0,0 +1,98 @@
+package ae
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"golang.org/x/net/context"
+)
+
+func TestTenantResolvers(t *testing.T) {
+	type test struct {
+		name    string
+		resolve TenantResolver
+		url     string
+		header  string
+		tenant  string
+	}
+
+	tests := []test{
+		test{name: "subdomain", resolve: SubdomainTenant("example.com"), url: "http://acme.example.com:8080/posts", tenant: "acme"},
+		test{name: "no subdomain", resolve: SubdomainTenant("example.com"), url: "http://example.com/posts", tenant: ""},
+		test{name: "header", resolve: HeaderTenant("X-Tenant"), url: "http://example.com/", header: "acme", tenant: "acme"},
+		test{name: "path", resolve: PathTenant("/t/"), url: "http://example.com/t/acme/posts", tenant: "acme"},
+		test{name: "no path", resolve: PathTenant("/t/"), url: "http://example.com/posts", tenant: ""},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", test.url, nil)
+		r.Header.Set("X-Tenant", test.header)
+		if tenant := test.resolve(r); tenant != test.tenant {
+			t.Errorf("%s: expected %q, got %q", test.name, test.tenant, tenant)
+		}
+	}
+}
+
+func TestTenantMiddleware(t *testing.T) {
+	mw := TenantMiddleware(HeaderTenant("X-Tenant"))
+
+	type test struct {
+		tenant string
+		status int
+	}
+
+	tests := []test{
+		test{tenant: "acme", status: http.StatusOK},
+		test{tenant: "", status: http.StatusNotFound},
+		test{tenant: "in valid", status: http.StatusBadRequest},
+	}
+
+	for _, test := range tests {
+		r, _ := http.NewRequest("GET", "/", nil)
+		r.Header.Set("X-Tenant", test.tenant)
+		w := httptest.NewRecorder()
+		c := mw(context.Background(), w, r)
+		if w.Code != test.status {
+			t.Errorf("%q: expected status %d, got %d", test.tenant, test.status, w.Code)
+			continue
+		}
+		if test.status == http.StatusOK && (c.Err() != nil || TenantFromContext(c) != test.tenant) {
+			t.Errorf("%q: tenant not set", test.tenant)
+		}
+	}
+}
+
+func TestTenantIsolation(t *testing.T) {
+	c := NewMemoryContext()
+	s := NewStore("people")
+	acme, _ := WithTenant(c, "acme")
+	other, _ := WithTenant(c, "other")
+
+	key, err := s.Create(acme, &memPerson{Name: "Jim"}, nil)
+	if err != nil || key.Namespace() != "acme" {
+		t.Errorf("not created within tenant: %v %v", key, err)
+		return
+	}
+	if keys, _ := s.GetAll(other, s.Query().KeysOnly(), nil); len(keys) != 0 {
+		t.Errorf("%d keys found within other tenant", len(keys))
+	}
+
+	var p memPerson
+	if _, err = s.Get(other, key, &p); err == nil {
+		t.Error("expected ErrTenantMismatch")
+	} else if _, ok := err.(ErrTenantMismatch); !ok {
+		t.Errorf("expected ErrTenantMismatch: %v", err)
+	}
+	if _, err = s.Get(CrossTenant(other), key, &p); err != nil || p.Name != "Jim" {
+		t.Errorf("cross tenant get failed: %v", err)
+	}
+
+	// cache items must not be shared between tenants
+	b := BackendFromContext(c)
+	b.CacheSet(acme, "foo", "acme", 0)
+	var val string
+	if err = b.CacheGet(other, "foo", &val); err == nil {
+		t.Errorf("cached item shared between tenants: %v", val)
+	}
+}
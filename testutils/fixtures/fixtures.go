@@ -0,0 +1,31 @@
+package fixtures
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/chrisolsen/ae/jsonl"
+	"golang.org/x/net/context"
+)
+
+// Load imports the JSON lines files, as created by jsonl.Export, into the
+// context's datastore. This is within its own package, rather than testutils,
+// as the ae package's tests depend on testutils.
+//  c := ae.NewMemoryContext()
+//  if err := fixtures.Load(c, "testdata/accounts.jsonl", "testdata/tags.jsonl"); err != nil {
+//  	t.Fatal(err)
+//  }
+func Load(c context.Context, paths ...string) error {
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		_, err = jsonl.Import(c, f)
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("loading %s: %v", path, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package ae
+
+import (
+	"crypto/sha1"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"golang.org/x/net/context"
+	"google.golang.org/appengine/datastore"
+)
+
+const uniqueTable = "unique"
+
+// unique values are reserved in entity groups other than the model's
+var uniqueTxOptions = &datastore.TransactionOptions{XG: true}
+
+// Uniquer is implemented by models with values that must be unique within the
+// store's table. The returned map contains the values by field name, with
+// empty values not being reserved. Multiple properties can be combined into a
+// single value to make them unique together.
+//  func (c *Credentials) UniqueValues() map[string]string {
+//  	return map[string]string{"Username": strings.ToLower(c.Username)}
+//  }
+type Uniquer interface {
+	UniqueValues() map[string]string
+}
+
+// ErrDuplicateValue is returned when saving a model with a unique value that
+// is already reserved by another model
+type ErrDuplicateValue struct {
+	Field string
+	Value string
+}
+
+func (e ErrDuplicateValue) Error() string {
+	return fmt.Sprintf("%s %q already exists", e.Field, e.Value)
+}
+
+// uniqueMarker reserves a unique value for the owning model
+type uniqueMarker struct {
+	Owner *datastore.Key
+}
+
+func isUniquer(model interface{}) bool {
+	_, ok := model.(Uniquer)
+	return ok
+}
+
+func uniqueValues(model interface{}) map[string]string {
+	u, ok := model.(Uniquer)
+	if !ok {
+		return nil
+	}
+	if v := reflect.ValueOf(model); v.Kind() == reflect.Ptr && v.IsNil() {
+		return nil
+	}
+	return u.UniqueValues()
+}
+
+// uniqueKey returns the marker key of the value, with the value being hashed
+// to keep it within the key name length limit
+func (s Store) uniqueKey(c context.Context, field, value string) *datastore.Key {
+	name := fmt.Sprintf("%s:%s:%x", s.TableName, field, sha1.Sum([]byte(value)))
+	return datastore.NewKey(c, uniqueTable, name, 0, nil)
+}
+
+// updateUnique reserves the new unique values and releases the old, returning
+// an ErrDuplicateValue if a value is reserved by another model. It must be
+// called within a cross group transaction.
+func (s Store) updateUnique(c context.Context, key *datastore.Key, oldValues, values map[string]string) error {
+	if oldValues == nil && values == nil {
+		return nil
+	}
+
+	// sorted for the duplicate errors to be consistent
+	var fields []string
+	for field := range values {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+
+	b := s.backend(c)
+	for _, field := range fields {
+		value := values[field]
+		if value == "" || value == oldValues[field] {
+			continue
+		}
+		markerKey := s.uniqueKey(c, field, value)
+		var marker uniqueMarker
+		err := b.Get(c, markerKey, &marker)
+		if err == nil && !marker.Owner.Equal(key) {
+			return ErrDuplicateValue{Field: field, Value: value}
+		}
+		if err != nil && err != datastore.ErrNoSuchEntity {
+			return err
+		}
+		if _, err = b.Put(c, markerKey, &uniqueMarker{Owner: key}); err != nil {
+			return err
+		}
+	}
+
+	for field, value := range oldValues {
+		if value == "" || value == values[field] {
+			continue
+		}
+		// values saved before the field was unique may not be owned by the model
+		markerKey := s.uniqueKey(c, field, value)
+		var marker uniqueMarker
+		err := b.Get(c, markerKey, &marker)
+		if err == datastore.ErrNoSuchEntity || (err == nil && !marker.Owner.Equal(key)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if err = b.Delete(c, markerKey); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReserveUnique reserves the unique values of the saved model, returning an
+// ErrDuplicateValue if a value is reserved by another model. Updates only
+// reserve the changed values, so the values of models saved before the model
+// implemented Uniquer must be backfilled with it.
+//  err := s.ReserveUnique(c, key, &user)
+func (s Store) ReserveUnique(c context.Context, key *datastore.Key, model interface{}) error {
+	if err := checkTenant(c, key); err != nil {
+		return err
+	}
+	return runInTransaction(s.backend(c), c, func(tc context.Context) error {
+		return s.updateUnique(tc, key, nil, uniqueValues(model))
+	}, uniqueTxOptions)
+}
+
+// putUnique saves the model and reserves its unique values within the same
+// transaction
+func (s Store) putUnique(c context.Context, key *datastore.Key, data interface{}) (*datastore.Key, error) {
+	b := s.backend(c)
+	err := runInTransaction(b, c, func(tc context.Context) error {
+		var oldValues map[string]string
+		if !key.Incomplete() {
+			current := reflect.New(reflect.TypeOf(data).Elem()).Interface()
+			err := b.Get(tc, key, current)
+			if err == nil || isFieldMismatch(err) {
+				oldValues = uniqueValues(current)
+			} else if err != datastore.ErrNoSuchEntity {
+				return err
+			}
+		}
+		k, err := b.Put(tc, key, data)
+		if err != nil {
+			return err
+		}
+		key = k
+		return s.updateUnique(tc, key, oldValues, uniqueValues(data))
+	}, uniqueTxOptions)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// deleteEntity deletes the entity, releasing the unique values of the store's
+// model within the same transaction
+func (s Store) deleteEntity(c context.Context, key *datastore.Key) error {
+	b := s.backend(c)
+	model := s.newModel()
+	if !isUniquer(model) {
+		return b.Delete(c, key)
+	}
+	return runInTransaction(b, c, func(tc context.Context) error {
+		err := b.Get(tc, key, model)
+		if err == datastore.ErrNoSuchEntity {
+			return nil
+		}
+		if err != nil && !isFieldMismatch(err) {
+			return err
+		}
+		if err = b.Delete(tc, key); err != nil {
+			return err
+		}
+		return s.updateUnique(tc, key, uniqueValues(model), nil)
+	}, uniqueTxOptions)
+}
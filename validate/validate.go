@@ -0,0 +1,257 @@
+package validate
+
+import (
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Errors contains the message of the first failed rule of each invalid field,
+// keyed by the field's JSON, form, query or param tag name, falling back to
+// the Go name.
+type Errors map[string]string
+
+func (e Errors) Error() string {
+	var fields []string
+	for field := range e {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	var msgs []string
+	for _, field := range fields {
+		msgs = append(msgs, field+" "+e[field])
+	}
+	return strings.Join(msgs, ", ")
+}
+
+// Rule checks a field's value against the rule's parameter, which is empty for
+// rules without one. Empty values are only checked by the required rule.
+type Rule func(v reflect.Value, param string) bool
+
+type rule struct {
+	fn      Rule
+	message string
+}
+
+var (
+	rulesMu sync.RWMutex
+	rules   = make(map[string]rule)
+)
+
+// Register adds a custom rule, with the message being formatted with the rule's
+// parameter when it contains a %s.
+//  validate.Register("slug", func(v reflect.Value, _ string) bool {
+//  	return slugRegexp.MatchString(v.String())
+//  }, "may only contain lowercase letters, digits and dashes")
+//
+//  type Post struct {
+//  	Slug string `json:"slug" validate:"required,slug"`
+//  }
+func Register(name string, fn Rule, message string) {
+	rulesMu.Lock()
+	defer rulesMu.Unlock()
+	rules[name] = rule{fn: fn, message: message}
+}
+
+func init() {
+	Register("required", func(v reflect.Value, _ string) bool { return !isEmpty(v) }, "is required")
+	Register("email", isEmail, "must be a valid email address")
+	Register("url", isURL, "must be a valid URL")
+	Register("min", func(v reflect.Value, p string) bool { return compare(v, p) >= 0 }, "must be at least %s")
+	Register("max", func(v reflect.Value, p string) bool { return compare(v, p) <= 0 }, "must be at most %s")
+	Register("len", func(v reflect.Value, p string) bool { return compare(v, p) == 0 }, "must have a length of %s")
+	Register("oneof", isOneOf, "must be one of %s")
+	Register("alphanum", isAlphanumeric, "may only contain letters and digits")
+}
+
+// Struct checks the fields of the struct, or struct pointer, against the rules
+// of their `validate` tags, returning Errors if any are invalid. The fields of
+// embedded structs are checked as if they were the struct's own.
+//  type Signup struct {
+//  	Email string `json:"email" validate:"required,email,max=120"`
+//  	Plan  string `json:"plan" validate:"required,oneof=free|pro"`
+//  }
+//
+//  if err := validate.Struct(&signup); err != nil {
+//  	// err.(validate.Errors)["plan"] == "must be one of free, pro"
+//  }
+func Struct(s interface{}) error {
+	v := reflect.Indirect(reflect.ValueOf(s))
+	if v.Kind() != reflect.Struct {
+		return fmt.Errorf("validate: %T is not a struct", s)
+	}
+	errs := make(Errors)
+	if err := checkStruct(v, errs); err != nil {
+		return err
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// HasRules indicates if any of the struct's fields have validation rules
+func HasRules(s interface{}) bool {
+	t := reflect.TypeOf(s)
+	for t != nil && t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t != nil && t.Kind() == reflect.Struct && hasRules(t)
+}
+
+func hasRules(t reflect.Type) bool {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Tag.Get("validate") != "" {
+			return true
+		}
+		if f.Anonymous && f.Type.Kind() == reflect.Struct && hasRules(f.Type) {
+			return true
+		}
+	}
+	return false
+}
+
+func checkStruct(v reflect.Value, errs Errors) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous && f.Type.Kind() == reflect.Struct {
+			if err := checkStruct(v.Field(i), errs); err != nil {
+				return err
+			}
+			continue
+		}
+		tag := f.Tag.Get("validate")
+		if tag == "" || tag == "-" || f.PkgPath != "" {
+			continue
+		}
+		name := fieldName(f)
+		if _, ok := errs[name]; ok {
+			continue
+		}
+		msg, err := checkField(v.Field(i), tag)
+		if err != nil {
+			return fmt.Errorf("validate: %s of %s: %v", f.Name, t, err)
+		}
+		if msg != "" {
+			errs[name] = msg
+		}
+	}
+	return nil
+}
+
+// checkField returns the message of the first failed rule of the tag
+func checkField(v reflect.Value, tag string) (string, error) {
+	rulesMu.RLock()
+	defer rulesMu.RUnlock()
+	for _, r := range strings.Split(tag, ",") {
+		name, param := r, ""
+		if i := strings.Index(r, "="); i >= 0 {
+			name, param = r[:i], r[i+1:]
+		}
+		rl, ok := rules[name]
+		if !ok {
+			return "", fmt.Errorf("unknown rule %q", name)
+		}
+		if name != "required" && isEmpty(v) {
+			continue
+		}
+		if rl.fn(reflect.Indirect(v), param) {
+			continue
+		}
+		if strings.Contains(rl.message, "%s") {
+			return fmt.Sprintf(rl.message, strings.Replace(param, "|", ", ", -1)), nil
+		}
+		return rl.message, nil
+	}
+	return "", nil
+}
+
+// fieldName returns the field's name within the request, or its Go name
+func fieldName(f reflect.StructField) string {
+	for _, key := range []string{"json", "form", "query", "param"} {
+		name := strings.Split(f.Tag.Get(key), ",")[0]
+		if name != "" && name != "-" {
+			return name
+		}
+	}
+	return f.Name
+}
+
+func isEmpty(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		return v.IsNil()
+	case reflect.String, reflect.Slice, reflect.Map, reflect.Array:
+		return v.Len() == 0
+	case reflect.Struct:
+		if z, ok := v.Interface().(interface {
+			IsZero() bool
+		}); ok {
+			return z.IsZero()
+		}
+		return false
+	default:
+		return v.Interface() == reflect.Zero(v.Type()).Interface()
+	}
+}
+
+// compare compares the length of strings, slices and maps, or the value of
+// numbers, against the parameter
+func compare(v reflect.Value, param string) int {
+	var n float64
+	switch v.Kind() {
+	case reflect.String:
+		n = float64(utf8.RuneCountInString(v.String()))
+	case reflect.Slice, reflect.Map, reflect.Array:
+		n = float64(v.Len())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n = float64(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n = float64(v.Uint())
+	case reflect.Float32, reflect.Float64:
+		n = v.Float()
+	}
+	p, _ := strconv.ParseFloat(param, 64)
+	switch {
+	case n < p:
+		return -1
+	case n > p:
+		return 1
+	}
+	return 0
+}
+
+func isEmail(v reflect.Value, _ string) bool {
+	addr, err := mail.ParseAddress(v.String())
+	return err == nil && addr.Address == v.String() && strings.Contains(addr.Address[strings.Index(addr.Address, "@"):], ".")
+}
+
+func isURL(v reflect.Value, _ string) bool {
+	u, err := url.Parse(v.String())
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+func isOneOf(v reflect.Value, param string) bool {
+	s := fmt.Sprint(v.Interface())
+	for _, option := range strings.Split(param, "|") {
+		if s == option {
+			return true
+		}
+	}
+	return false
+}
+
+var alphanumRegexp = regexp.MustCompile(`^[\pL\pN]+$`)
+
+func isAlphanumeric(v reflect.Value, _ string) bool {
+	return alphanumRegexp.MatchString(v.String())
+}
@@ -0,0 +1,81 @@
+package validate
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type base struct {
+	Name string `json:"name" validate:"required,max=5"`
+}
+
+type signup struct {
+	base
+	Email    string   `json:"email,omitempty" validate:"required,email"`
+	Plan     string   `form:"plan" validate:"oneof=free|pro"`
+	Age      int      `validate:"min=18"`
+	Tags     []string `json:"tags" validate:"max=2"`
+	Website  string   `json:"website" validate:"url"`
+	Username string   `json:"username" validate:"slug"`
+	ignored  string   `validate:"required"`
+}
+
+func TestStruct(t *testing.T) {
+	Register("slug", func(v reflect.Value, _ string) bool {
+		return !strings.ContainsAny(v.String(), " _")
+	}, "may only contain letters, digits and dashes")
+
+	valid := signup{
+		base:     base{Name: "jim"},
+		Email:    "jim@example.com",
+		Plan:     "pro",
+		Age:      18,
+		Tags:     []string{"a"},
+		Website:  "https://example.com",
+		Username: "jim-bob",
+	}
+
+	type test struct {
+		mutate func(s *signup)
+		field  string
+		msg    string
+	}
+
+	tests := []test{
+		test{mutate: func(s *signup) {}},
+		test{mutate: func(s *signup) { s.Plan, s.Age, s.Website = "", 0, "" }},
+		test{mutate: func(s *signup) { s.Name = "" }, field: "name", msg: "is required"},
+		test{mutate: func(s *signup) { s.Name = "jimmy bob" }, field: "name", msg: "must be at most 5"},
+		test{mutate: func(s *signup) { s.Email = "" }, field: "email", msg: "is required"},
+		test{mutate: func(s *signup) { s.Email = "jim@" }, field: "email", msg: "must be a valid email address"},
+		test{mutate: func(s *signup) { s.Plan = "gold" }, field: "plan", msg: "must be one of free, pro"},
+		test{mutate: func(s *signup) { s.Age = 17 }, field: "Age", msg: "must be at least 18"},
+		test{mutate: func(s *signup) { s.Tags = []string{"a", "b", "c"} }, field: "tags", msg: "must be at most 2"},
+		test{mutate: func(s *signup) { s.Website = "example.com" }, field: "website", msg: "must be a valid URL"},
+		test{mutate: func(s *signup) { s.Username = "jim bob" }, field: "username", msg: "may only contain letters, digits and dashes"},
+	}
+
+	for i, test := range tests {
+		s := valid
+		test.mutate(&s)
+		err := Struct(&s)
+		if test.field == "" {
+			if err != nil {
+				t.Errorf("%d. unexpected error: %v", i, err)
+			}
+			continue
+		}
+		errs, ok := err.(Errors)
+		if !ok || len(errs) != 1 || errs[test.field] != test.msg {
+			t.Errorf("%d. expected %s %s: %v", i, test.field, test.msg, err)
+		}
+	}
+
+	err := Struct(struct {
+		Name string `validate:"unknown"`
+	}{"jim"})
+	if _, ok := err.(Errors); err == nil || ok {
+		t.Errorf("unknown rule not returned: %v", err)
+	}
+}